@@ -0,0 +1,58 @@
+// Package baseline 保留改造之前的单队列实现, 仅用于基准测试对比
+// 一把互斥锁保护任务队列和并发计数, 没有截止时间, 统计, 钩子等功能, 每个任务不需要分配元素也不需要取时间.
+package baseline
+
+import (
+	"sync"
+
+	"github.com/lxzan/dao/deque"
+)
+
+type Queue struct {
+	mu             sync.Mutex
+	q              *deque.Deque[func()]
+	maxConcurrency int32
+	curConcurrency int32
+}
+
+// New 创建一条任务队列
+func New(concurrency int) *Queue {
+	return &Queue{
+		q:              deque.New[func()](8),
+		maxConcurrency: int32(concurrency),
+	}
+}
+
+// 获取一个任务
+func (c *Queue) getJob(newJob func(), delta int32) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if newJob != nil {
+		c.q.PushBack(newJob)
+	}
+	c.curConcurrency += delta
+	if c.curConcurrency >= c.maxConcurrency {
+		return nil
+	}
+	if job := c.q.PopFront(); job != nil {
+		c.curConcurrency++
+		return job
+	}
+	return nil
+}
+
+// 循环执行任务
+func (c *Queue) do(job func()) {
+	for job != nil {
+		job()
+		job = c.getJob(nil, -1)
+	}
+}
+
+// Push 追加任务, 有资源空闲的话会立即执行
+func (c *Queue) Push(job func()) {
+	if nextJob := c.getJob(job, 0); nextJob != nil {
+		go c.do(nextJob)
+	}
+}
@@ -2,10 +2,12 @@ package benchmark
 
 import (
 	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/lxzan/concurrency/benchmark/baseline"
 	"github.com/lxzan/concurrency/queues"
 	"github.com/panjf2000/ants/v2"
 	"sync"
 	"testing"
+	"time"
 )
 
 const (
@@ -101,3 +103,74 @@ func Benchmark_GoPool(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+// 多个协程同时追加任务, 衡量分片锁的竞争开销
+func benchmarkParallelPush(b *testing.B, q queues.Queue) {
+	wg := &sync.WaitGroup{}
+	job := func() { wg.Done() }
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			q.Push(job)
+		}
+	})
+	wg.Wait()
+}
+
+func Benchmark_QueuesSingleParallel(b *testing.B) {
+	benchmarkParallelPush(b, queues.New(
+		queues.WithConcurrency(Concurrency),
+		queues.WithSharding(1),
+	))
+}
+
+// 改造之前的单队列, 作为 Benchmark_QueuesSingleParallel 的对照
+func Benchmark_BaselineSingleParallel(b *testing.B) {
+	var q = baseline.New(Concurrency)
+	wg := &sync.WaitGroup{}
+	job := func() { wg.Done() }
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			q.Push(job)
+		}
+	})
+	wg.Wait()
+}
+
+func Benchmark_QueuesMultipleParallel(b *testing.B) {
+	benchmarkParallelPush(b, queues.New(
+		queues.WithConcurrency(1),
+		queues.WithSharding(Concurrency),
+	))
+}
+
+// 每轮突发追加一批极小的任务并等待完成, 衡量协程创建与销毁的开销
+func benchmarkBurst(b *testing.B, q queues.Queue) {
+	for i := 0; i < b.N; i++ {
+		wg := &sync.WaitGroup{}
+		wg.Add(M)
+		job := func() { wg.Done() }
+		for j := 0; j < M; j++ {
+			q.Push(job)
+		}
+		wg.Wait()
+	}
+}
+
+func Benchmark_QueuesBurst(b *testing.B) {
+	benchmarkBurst(b, queues.New(
+		queues.WithConcurrency(Concurrency),
+		queues.WithSharding(1),
+	))
+}
+
+func Benchmark_QueuesBurstWorkerPool(b *testing.B) {
+	benchmarkBurst(b, queues.New(
+		queues.WithConcurrency(Concurrency),
+		queues.WithSharding(1),
+		queues.WithWorkerPool(time.Second),
+	))
+}
@@ -7,7 +7,6 @@ replace github.com/lxzan/concurrency v0.0.0 => ../
 require (
 	github.com/bytedance/gopkg v0.0.0-20230728082804-614d0af6619b
 	github.com/lxzan/concurrency v0.0.0
+	github.com/lxzan/dao v1.1.12
 	github.com/panjf2000/ants/v2 v2.8.1
 )
-
-require github.com/lxzan/dao v1.1.12 // indirect
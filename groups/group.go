@@ -8,11 +8,14 @@ import (
 	"time"
 
 	"github.com/lxzan/concurrency/internal"
+	"github.com/lxzan/concurrency/tracing"
 )
 
 const (
 	defaultConcurrency = 8                // 默认并发度
 	defaultWaitTimeout = 60 * time.Second // 默认线程同步等待超时
+
+	spanName = "groups.task" // 任务 span 的名称
 )
 
 var defaultCaller Caller = func(args any, f func(any) error) error { return f(args) }
@@ -20,6 +23,32 @@ var defaultCaller Caller = func(args any, f func(any) error) error { return f(ar
 type (
 	Caller func(args any, f func(any) error) error
 
+	// Histogram 时长分布
+	Histogram = internal.Histogram
+
+	// Stats 任务组统计快照
+	Stats struct {
+		Pending     int       // 剩余任务数量
+		Running     int       // 执行中的任务数量
+		Completed   uint64    // 已执行完成的任务数量
+		Failed      uint64    // 返回错误的任务数量, 包含发生 panic 的任务
+		Panicked    uint64    // 发生 panic 的任务数量, 需要开启 WithRecovery
+		ExecLatency Histogram // 执行时长分布
+	}
+
+	// PanicError 开启 WithRecovery 时, 任务发生 panic 后返回的错误
+	PanicError struct {
+		Value any    // panic 的值
+		Stack string // 调用栈
+	}
+
+	// 队列中的任务
+	task[T any] struct {
+		args     T               // 任务参数
+		ctx      context.Context // 追加任务时的上下文, 执行时替换为任务上下文
+		pushedAt time.Time       // 追加任务的时间
+	}
+
 	Group[T any] struct {
 		options    *options                // 配置
 		mu         sync.Mutex              // 锁
@@ -28,14 +57,26 @@ type (
 		canceled   atomic.Uint32           // 是否已取消
 		errs       []error                 // 错误
 		done       chan bool               // 完成信号
-		q          []T                     // 任务队列
+		q          []task[T]               // 任务队列
 		taskDone   int64                   // 已完成任务数量
 		taskTotal  int64                   // 总任务数量
+		running    int                     // 执行中的任务数量
+		failed     uint64                  // 返回错误的任务数量
+		panicked   uint64                  // 发生 panic 的任务数量
+		exec       internal.Recorder       // 执行时长分布
 		OnMessage  func(args T) error      // 任务处理
 		OnError    func(args T, err error) // 错误处理
+
+		// OnMessageContext 携带上下文的任务处理, 设置后代替 OnMessage
+		// 上下文在任务组超时或者取消后取消, 并携带 PushContext 传入的上下文中的值(例如链路追踪信息)
+		OnMessageContext func(ctx context.Context, args T) error
 	}
 )
 
+func (c *PanicError) Error() string {
+	return c.Stack
+}
+
 // New 新建一个任务集
 func New[T any](opts ...Option) *Group[T] {
 	o := new(options)
@@ -46,7 +87,7 @@ func New[T any](opts ...Option) *Group[T] {
 
 	c := &Group[T]{
 		options:  o,
-		q:        make([]T, 0),
+		q:        make([]task[T], 0),
 		taskDone: 0,
 		done:     make(chan bool),
 	}
@@ -65,7 +106,7 @@ func (c *Group[T]) clearJob() {
 	c.mu.Unlock()
 }
 
-func (c *Group[T]) getJob() (v T, ok bool) {
+func (c *Group[T]) getJob() (v task[T], ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -74,6 +115,7 @@ func (c *Group[T]) getJob() (v T, ok bool) {
 	}
 	var result = c.q[0]
 	c.q = c.q[1:]
+	c.running++
 	return result, true
 }
 
@@ -97,15 +139,57 @@ func (c *Group[T]) jobFunc(v any) error {
 	if c.canceled.Load() == 1 {
 		return nil
 	}
-	return c.OnMessage(v.(T))
+	var t = v.(task[T])
+	if c.OnMessageContext != nil {
+		return c.OnMessageContext(t.ctx, t.args)
+	}
+	return c.OnMessage(t.args)
 }
 
-func (c *Group[T]) do(args T) {
-	if err := c.options.caller(args, c.jobFunc); err != nil {
-		c.mu.Lock()
+// 记录任务执行结果
+func (c *Group[T]) record(err error, elapsed time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.running--
+	c.exec.Observe(elapsed)
+	if err != nil {
 		c.errs = append(c.errs, err)
-		c.mu.Unlock()
-		c.OnError(args, err)
+		c.failed++
+		var e *PanicError
+		if errors.As(err, &e) {
+			c.panicked++
+		}
+	}
+}
+
+func (c *Group[T]) do(t task[T]) {
+	var start = time.Now()
+	var span tracing.Span
+	var pushed = t.ctx
+	if c.options.tracer != nil {
+		pushed, span = c.options.tracer.Start(t.ctx, spanName, t.pushedAt)
+		span.AddEvent(tracing.EventJobStart, start)
+	}
+	t.ctx = internal.WithValues(c.ctx, pushed)
+
+	var err = c.options.caller(t, c.jobFunc)
+	var end = time.Now()
+	c.record(err, end.Sub(start))
+	if span != nil {
+		var e *PanicError
+		span.SetAttributes(
+			tracing.Int64(tracing.AttrWait, int64(start.Sub(t.pushedAt))),
+			tracing.Int64(tracing.AttrExec, int64(end.Sub(start))),
+			tracing.Bool(tracing.AttrPanicked, errors.As(err, &e)),
+		)
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End(end)
+	}
+	if err != nil {
+		c.OnError(t.args, err)
 	}
 
 	if c.incrAndIsDone() {
@@ -126,6 +210,20 @@ func (c *Group[T]) Len() int {
 	return x
 }
 
+// Stats 获取统计快照
+func (c *Group[T]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Pending:     len(c.q),
+		Running:     c.running,
+		Completed:   uint64(c.taskDone),
+		Failed:      c.failed,
+		Panicked:    c.panicked,
+		ExecLatency: c.exec.Snapshot(),
+	}
+}
+
 // Cancel 取消队列中剩余任务的执行
 func (c *Group[T]) Cancel() {
 	if c.canceled.CompareAndSwap(0, 1) {
@@ -135,9 +233,18 @@ func (c *Group[T]) Cancel() {
 
 // Push 往任务队列中追加任务
 func (c *Group[T]) Push(eles ...T) {
+	c.PushContext(context.Background(), eles...)
+}
+
+// PushContext 往任务队列中追加任务
+// ctx 中的值(例如链路追踪信息)会传递给 OnMessageContext 的上下文, 开启 WithTracer 时任务的 span 作为 ctx 中的 span 的子 span
+func (c *Group[T]) PushContext(ctx context.Context, eles ...T) {
+	var now = time.Now()
 	c.mu.Lock()
 	c.taskTotal += int64(len(eles))
-	c.q = append(c.q, eles...)
+	for _, v := range eles {
+		c.q = append(c.q, task[T]{args: v, ctx: ctx, pushedAt: now})
+	}
 	c.mu.Unlock()
 }
 
@@ -1,11 +1,13 @@
 package groups
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/lxzan/concurrency/tracing"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
@@ -236,4 +238,86 @@ func TestNewTaskGroup(t *testing.T) {
 		// Start后队列应该为空
 		as.Equal(0, ctl.Len())
 	})
+
+	t.Run("stats", func(t *testing.T) {
+		ctl := New[int](WithConcurrency(2), WithRecovery())
+		ctl.Push(1, 2, 3, 4, 5)
+		as.Equal(5, ctl.Stats().Pending)
+		ctl.OnMessage = func(args int) error {
+			switch args {
+			case 1:
+				return errors.New("test")
+			case 2:
+				var m map[int]int
+				m[0] = 1
+			}
+			return nil
+		}
+		as.Error(ctl.Start())
+		var s = ctl.Stats()
+		as.Equal(0, s.Pending)
+		as.Equal(0, s.Running)
+		as.Equal(uint64(5), s.Completed)
+		as.Equal(uint64(2), s.Failed)
+		as.Equal(uint64(1), s.Panicked)
+		as.Equal(uint64(5), s.ExecLatency.Count)
+	})
+
+	t.Run("panic error", func(t *testing.T) {
+		ctl := New[int](WithRecovery())
+		ctl.Push(1)
+		ctl.OnMessage = func(args int) error {
+			panic("test")
+		}
+		var err = ctl.Start()
+		var e *PanicError
+		as.True(errors.As(err, &e))
+		as.Equal("test", e.Value)
+		as.Contains(e.Error(), "goroutine")
+	})
+
+	t.Run("tracing", func(t *testing.T) {
+		var recorder = tracing.NewRecorder()
+		ctx, parent := recorder.Start(context.Background(), "parent", time.Now())
+		ctl := New[int](WithConcurrency(1), WithTracer(recorder), WithRecovery())
+		ctl.PushContext(ctx, 1, 2)
+		ctl.Push(3)
+		var spanIDs = make(map[int]uint64)
+		ctl.OnMessageContext = func(ctx context.Context, args int) error {
+			ctl.Update(func() { spanIDs[args] = tracing.SpanID(ctx) })
+			switch args {
+			case 1:
+				return errors.New("test")
+			case 2:
+				panic("test")
+			}
+			return nil
+		}
+		as.Error(ctl.Start())
+		parent.End(time.Now())
+
+		var spans = recorder.Spans()
+		as.Len(spans, 4)
+		for _, span := range spans[:3] {
+			as.Equal("groups.task", span.Name)
+			as.Equal(span.ID, spanIDs[int(span.ID)-1])
+			as.Len(span.Events, 1)
+			wait, _ := span.Attribute(tracing.AttrWait)
+			as.GreaterOrEqual(wait.(int64), int64(0))
+			panicked, _ := span.Attribute(tracing.AttrPanicked)
+			switch span.ID {
+			case 2:
+				as.Equal(uint64(1), span.ParentID)
+				as.Len(span.Errors, 1)
+				as.Equal(false, panicked)
+			case 3:
+				as.Equal(uint64(1), span.ParentID)
+				as.Len(span.Errors, 1)
+				as.Equal(true, panicked)
+			case 4:
+				as.Equal(uint64(0), span.ParentID)
+				as.Empty(span.Errors)
+			}
+		}
+	})
 }
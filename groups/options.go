@@ -2,7 +2,7 @@ package groups
 
 import (
 	"github.com/lxzan/concurrency/internal"
-	"github.com/pkg/errors"
+	"github.com/lxzan/concurrency/tracing"
 	"runtime"
 	"time"
 	"unsafe"
@@ -12,6 +12,7 @@ type options struct {
 	timeout     time.Duration
 	concurrency int64
 	caller      Caller
+	tracer      tracing.Tracer
 }
 
 type Option func(o *options)
@@ -30,6 +31,13 @@ func WithConcurrency(n uint32) Option {
 	}
 }
 
+// WithTracer 开启链路追踪, 为每个任务创建 span, 记录排队等待时长, 执行时长, 错误和 panic
+func WithTracer(tracer tracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
 // WithRecovery 设置恢复程序
 func WithRecovery() Option {
 	return func(o *options) {
@@ -40,7 +48,7 @@ func WithRecovery() Option {
 					buf := make([]byte, size)
 					buf = buf[:runtime.Stack(buf, false)]
 					msg := *(*string)(unsafe.Pointer(&buf))
-					err = errors.New(msg)
+					err = &PanicError{Value: e, Stack: msg}
 				}
 			}()
 
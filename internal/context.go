@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"context"
+)
+
+// 取消信号来自 Context, 值来自 values
+type valueContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c *valueContext) Value(key any) any {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+// WithValues 返回一个上下文, 取消信号和截止时间来自 parent, 值优先从 values 中查找
+// values 为空或者为 context.Background 时直接返回 parent
+func WithValues(parent, values context.Context) context.Context {
+	if values == nil || values == context.Background() {
+		return parent
+	}
+	return &valueContext{Context: parent, values: values}
+}
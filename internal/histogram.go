@@ -0,0 +1,96 @@
+package internal
+
+import "time"
+
+// LatencyBuckets 时长分布的桶上界
+var LatencyBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	time.Minute,
+}
+
+type (
+	// Histogram 时长分布
+	Histogram struct {
+		Buckets []time.Duration // 各个桶的上界
+		Counts  []uint64        // 各个桶的计数, 比 Buckets 多一个桶用于统计超出最大上界的样本
+		Count   uint64          // 样本数量
+		Sum     time.Duration   // 样本总和
+	}
+
+	// Recorder 时长分布记录器, 非线程安全
+	Recorder struct {
+		counts [len(LatencyBuckets) + 1]uint64
+		count  uint64
+		sum    time.Duration
+	}
+)
+
+// Observe 记录一个样本
+func (c *Recorder) Observe(d time.Duration) {
+	var i = 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	c.counts[i]++
+	c.count++
+	c.sum += d
+}
+
+// Snapshot 获取时长分布快照
+func (c *Recorder) Snapshot() Histogram {
+	var counts = make([]uint64, len(c.counts))
+	copy(counts, c.counts[:])
+	return Histogram{
+		Buckets: LatencyBuckets[:],
+		Counts:  counts,
+		Count:   c.count,
+		Sum:     c.sum,
+	}
+}
+
+// Mean 平均时长
+func (c Histogram) Mean() time.Duration {
+	if c.Count == 0 {
+		return 0
+	}
+	return c.Sum / time.Duration(c.Count)
+}
+
+// Quantile 估算分位数(0 < q <= 1), 返回分位数所在桶的上界
+// 落在最后一个桶时返回最大上界
+func (c Histogram) Quantile(q float64) time.Duration {
+	if c.Count == 0 || len(c.Buckets) == 0 {
+		return 0
+	}
+	var rank = uint64(q * float64(c.Count))
+	var sum = uint64(0)
+	for i, bound := range c.Buckets {
+		if sum += c.Counts[i]; sum >= rank && sum > 0 {
+			return bound
+		}
+	}
+	return c.Buckets[len(c.Buckets)-1]
+}
+
+// Merge 聚合另一个时长分布
+func (c *Histogram) Merge(h Histogram) {
+	if c.Counts == nil {
+		c.Buckets = h.Buckets
+		c.Counts = make([]uint64, len(h.Counts))
+	}
+	for i, n := range h.Counts {
+		c.Counts[i] += n
+	}
+	c.Count += h.Count
+	c.Sum += h.Sum
+}
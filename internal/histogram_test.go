@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("empty", func(t *testing.T) {
+		var r Recorder
+		as.Equal(time.Duration(0), r.Snapshot().Quantile(0.5))
+		as.Equal(time.Duration(0), r.Snapshot().Mean())
+		as.Equal(time.Duration(0), Histogram{}.Quantile(0.5))
+	})
+
+	t.Run("quantile", func(t *testing.T) {
+		var r Recorder
+		for i := 0; i < 90; i++ {
+			r.Observe(50 * time.Microsecond)
+		}
+		for i := 0; i < 9; i++ {
+			r.Observe(20 * time.Millisecond)
+		}
+		r.Observe(time.Hour)
+		var h = r.Snapshot()
+		as.Equal(uint64(100), h.Count)
+		as.Len(h.Counts, len(h.Buckets)+1)
+		as.Equal(100*time.Microsecond, h.Quantile(0.5))
+		as.Equal(50*time.Millisecond, h.Quantile(0.99))
+		as.Equal(time.Minute, h.Quantile(1))
+		as.Equal(uint64(1), h.Counts[len(h.Counts)-1])
+		as.Equal((4500*time.Microsecond+180*time.Millisecond+time.Hour)/100, h.Mean())
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		var a, b Recorder
+		a.Observe(time.Millisecond)
+		b.Observe(time.Second)
+		b.Observe(time.Second)
+		var h Histogram
+		h.Merge(a.Snapshot())
+		h.Merge(b.Snapshot())
+		as.Equal(uint64(3), h.Count)
+		as.Equal(2*time.Second+time.Millisecond, h.Sum)
+		as.Equal(uint64(1), h.Counts[2])
+		as.Equal(uint64(2), h.Counts[8])
+	})
+}
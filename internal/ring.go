@@ -0,0 +1,84 @@
+package internal
+
+// Ring 可扩容的环形缓冲区, 容量总是2的幂, 非线程安全
+// 元素连续存放, 弹出时清零槽位以释放引用, 相比链表实现减少了持有锁期间的内存访问和拷贝
+type Ring[T any] struct {
+	head   int // 队首下标
+	length int // 长度
+	buf    []T
+}
+
+// NewRing 创建环形缓冲区, capacity 为初始容量
+func NewRing[T any](capacity int) *Ring[T] {
+	return &Ring[T]{buf: make([]T, ToBinaryNumber(SelectValue(capacity <= 0, 1, capacity)))}
+}
+
+func (c *Ring[T]) Len() int { return c.length }
+
+// 第 i 个元素在缓冲区中的下标
+func (c *Ring[T]) index(i int) int {
+	return (c.head + i) & (len(c.buf) - 1)
+}
+
+// 容量翻倍, 元素按顺序移动到新缓冲区的开头
+func (c *Ring[T]) grow() {
+	var buf = make([]T, SelectValue(len(c.buf) == 0, 8, 2*len(c.buf)))
+	var n = copy(buf, c.buf[c.head:])
+	copy(buf[n:], c.buf[:c.head])
+	c.head, c.buf = 0, buf
+}
+
+// PushBack 追加到队尾
+func (c *Ring[T]) PushBack(v T) {
+	if c.length == len(c.buf) {
+		c.grow()
+	}
+	c.buf[c.index(c.length)] = v
+	c.length++
+}
+
+// PopFront 弹出队首元素
+func (c *Ring[T]) PopFront() (v T, ok bool) {
+	if c.length == 0 {
+		return v, false
+	}
+	var zero T
+	v, c.buf[c.head] = c.buf[c.head], zero
+	c.head = c.index(1)
+	c.length--
+	return v, true
+}
+
+// PopBack 弹出队尾元素
+func (c *Ring[T]) PopBack() (v T, ok bool) {
+	if c.length == 0 {
+		return v, false
+	}
+	var zero T
+	var i = c.index(c.length - 1)
+	v, c.buf[i] = c.buf[i], zero
+	c.length--
+	return v, true
+}
+
+// Get 获取第 i 个元素的指针, i 从队首开始计数
+func (c *Ring[T]) Get(i int) *T {
+	return &c.buf[c.index(i)]
+}
+
+// Remove 移除并返回第 i 个元素, 移动较短的一侧
+func (c *Ring[T]) Remove(i int) T {
+	var v = *c.Get(i)
+	if i < c.length/2 {
+		for j := i; j > 0; j-- {
+			*c.Get(j) = *c.Get(j - 1)
+		}
+		c.PopFront()
+		return v
+	}
+	for j := i; j < c.length-1; j++ {
+		*c.Get(j) = *c.Get(j + 1)
+	}
+	c.PopBack()
+	return v
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/lxzan/dao/deque"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("fifo", func(t *testing.T) {
+		var r = NewRing[int](0)
+		for i := 0; i < 100; i++ {
+			r.PushBack(i)
+		}
+		as.Equal(100, r.Len())
+		for i := 0; i < 100; i++ {
+			v, ok := r.PopFront()
+			as.True(ok)
+			as.Equal(i, v)
+		}
+		_, ok := r.PopFront()
+		as.False(ok)
+		_, ok = r.PopBack()
+		as.False(ok)
+	})
+
+	t.Run("zero value", func(t *testing.T) {
+		var r Ring[int]
+		r.PushBack(1)
+		r.PushBack(2)
+		v, _ := r.PopBack()
+		as.Equal(2, v)
+		as.Equal(1, *r.Get(0))
+	})
+
+	t.Run("random", func(t *testing.T) {
+		var r = NewRing[int](4)
+		var s []int
+		for i := 0; i < 10000; i++ {
+			switch n := rand.Intn(10); {
+			case n < 5:
+				r.PushBack(i)
+				s = append(s, i)
+			case n < 7:
+				v, ok := r.PopFront()
+				as.Equal(len(s) > 0, ok)
+				if ok {
+					as.Equal(s[0], v)
+					s = s[1:]
+				}
+			case n < 8:
+				v, ok := r.PopBack()
+				as.Equal(len(s) > 0, ok)
+				if ok {
+					as.Equal(s[len(s)-1], v)
+					s = s[:len(s)-1]
+				}
+			default:
+				if len(s) > 0 {
+					var j = rand.Intn(len(s))
+					as.Equal(s[j], r.Remove(j))
+					s = append(s[:j:j], s[j+1:]...)
+				}
+			}
+			as.Equal(len(s), r.Len())
+		}
+		for i, v := range s {
+			as.Equal(v, *r.Get(i))
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		var r = NewRing[*int](2)
+		r.PushBack(new(int))
+		r.PushBack(new(int))
+		r.PopFront()
+		r.PopBack()
+		for _, v := range r.buf {
+			as.Nil(v)
+		}
+	})
+}
+
+type benchElement struct {
+	fn      func()
+	payload [16]int64
+}
+
+func BenchmarkRing(b *testing.B) {
+	var r = NewRing[benchElement](8)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 64; j++ {
+			r.PushBack(benchElement{})
+		}
+		for j := 0; j < 64; j++ {
+			r.PopFront()
+		}
+	}
+}
+
+func BenchmarkDeque(b *testing.B) {
+	var q = deque.New[benchElement](8)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 64; j++ {
+			q.PushBack(benchElement{})
+		}
+		for j := 0; j < 64; j++ {
+			q.PopFront()
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package internal
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestToBinaryNumber(t *testing.T) {
@@ -38,3 +40,18 @@ func TestSelectValue(t *testing.T) {
 	assert.Equal(t, SelectValue(true, 1, 2), 1)
 	assert.Equal(t, SelectValue(false, 1, 2), 2)
 }
+
+func TestWithValues(t *testing.T) {
+	type key struct{}
+	var as = assert.New(t)
+	var parent, cancel = context.WithCancel(context.Background())
+	as.Equal(parent, WithValues(parent, nil))
+	as.Equal(parent, WithValues(parent, context.Background()))
+
+	var ctx = WithValues(parent, context.WithValue(context.Background(), key{}, 1))
+	as.Equal(1, ctx.Value(key{}))
+	as.NoError(ctx.Err())
+	cancel()
+	<-ctx.Done()
+	as.ErrorIs(ctx.Err(), context.Canceled)
+}
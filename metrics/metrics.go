@@ -0,0 +1,275 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lxzan/concurrency/groups"
+	"github.com/lxzan/concurrency/internal"
+	"github.com/lxzan/concurrency/queues"
+)
+
+// ContentType Prometheus 文本格式的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// ErrDuplicateName 名称已被注册
+var ErrDuplicateName = errors.New("metrics: duplicate name")
+
+type (
+	// QueueStater 可以获取统计快照的任务队列, queues.Queue 实现了该接口
+	QueueStater interface {
+		Stats() queues.Stats
+	}
+
+	// GroupStater 可以获取统计快照的任务组, *groups.Group 实现了该接口
+	GroupStater interface {
+		Stats() groups.Stats
+	}
+
+	// Registry 指标注册表
+	// 以 Prometheus 文本格式导出已注册的任务队列和任务组的指标, 同时实现了 http.Handler
+	Registry struct {
+		mu        sync.Mutex
+		namespace string
+		queues    map[string]QueueStater
+		groups    map[string]GroupStater
+	}
+
+	// 指标族
+	family struct {
+		name    string
+		help    string
+		kind    string
+		samples []sample
+	}
+
+	// 指标样本
+	sample struct {
+		suffix string
+		labels []string // 标签键值对
+		value  float64
+	}
+)
+
+// NewRegistry 新建指标注册表, namespace 为指标名称前缀, 为空时使用 concurrency
+func NewRegistry(namespace string) *Registry {
+	return &Registry{
+		namespace: internal.SelectValue(namespace == "", "concurrency", namespace),
+		queues:    make(map[string]QueueStater),
+		groups:    make(map[string]GroupStater),
+	}
+}
+
+// RegisterQueue 注册任务队列, name 作为 queue 标签的值
+// 排队等待时长和执行时长的分布需要队列开启 queues.WithLatencyStats
+func (c *Registry) RegisterQueue(name string, q QueueStater) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.queues[name]; ok {
+		return ErrDuplicateName
+	}
+	c.queues[name] = q
+	return nil
+}
+
+// RegisterGroup 注册任务组, name 作为 group 标签的值
+func (c *Registry) RegisterGroup(name string, g GroupStater) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.groups[name]; ok {
+		return ErrDuplicateName
+	}
+	c.groups[name] = g
+	return nil
+}
+
+// Unregister 注销任务队列或者任务组
+func (c *Registry) Unregister(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.queues, name)
+	delete(c.groups, name)
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出指标
+func (c *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf = bytes.NewBuffer(nil)
+	if _, err := c.WriteTo(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// WriteTo 以 Prometheus 文本格式写入指标
+func (c *Registry) WriteTo(w io.Writer) (int64, error) {
+	var writer = &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range c.collect() {
+		f.write(writer)
+	}
+	if writer.err == nil {
+		writer.err = writer.w.Flush()
+	}
+	return writer.n, writer.err
+}
+
+// 收集所有指标
+func (c *Registry) collect() []*family {
+	c.mu.Lock()
+	var qs = make(map[string]QueueStater, len(c.queues))
+	var gs = make(map[string]GroupStater, len(c.groups))
+	for k, v := range c.queues {
+		qs[k] = v
+	}
+	for k, v := range c.groups {
+		gs[k] = v
+	}
+	c.mu.Unlock()
+
+	var families = make([]*family, 0)
+	var newFamily = func(name, help, kind string) *family {
+		var f = &family{name: c.namespace + "_" + name, help: help, kind: kind}
+		families = append(families, f)
+		return f
+	}
+
+	var queuePending = newFamily("queue_pending", "Number of jobs waiting in the queue.", "gauge")
+	var queueShardPending = newFamily("queue_shard_pending", "Number of jobs waiting in each shard of the queue.", "gauge")
+	var queueRunning = newFamily("queue_running", "Number of jobs currently running.", "gauge")
+	var queueIdle = newFamily("queue_idle_workers", "Number of idle pooled workers waiting for jobs.", "gauge")
+	var queuePaused = newFamily("queue_paused", "Whether the queue is paused (1) or not (0).", "gauge")
+	var queueCompleted = newFamily("queue_completed_total", "Total number of jobs completed.", "counter")
+	var queuePanics = newFamily("queue_panics_total", "Total number of jobs that panicked.", "counter")
+	var queueFailures = newFamily("queue_failures_total", "Total number of jobs that returned an error and were not retried.", "counter")
+	var queueRetries = newFamily("queue_retries_total", "Total number of job retries.", "counter")
+	var queueDeduplicated = newFamily("queue_deduplicated_total", "Total number of unique jobs dropped or replaced by a later push with the same key.", "counter")
+	var queueExpired = newFamily("queue_expired_total", "Total number of jobs dropped because their deadline passed before they started.", "counter")
+	var queueTimeouts = newFamily("queue_timeouts_total", "Total number of jobs that ran past their execution timeout.", "counter")
+	var queueAbandoned = newFamily("queue_abandoned_total", "Total number of timed out jobs that ignored cancellation and had their slot released.", "counter")
+	var queueRejections = newFamily("queue_rejections_total", "Total number of jobs rejected or dropped because the queue was full.", "counter")
+	var queueWait = newFamily("queue_wait_seconds", "Time jobs spent waiting in the queue.", "histogram")
+	var queueDuration = newFamily("queue_job_duration_seconds", "Time spent executing jobs.", "histogram")
+	for _, name := range sortedKeys(qs) {
+		var s = qs[name].Stats()
+		var labels = []string{"queue", name}
+		queuePending.add("", labels, float64(s.Pending))
+		for i, shard := range s.Shards {
+			queueShardPending.add("", []string{"queue", name, "shard", strconv.Itoa(i)}, float64(shard.Pending))
+		}
+		queueRunning.add("", labels, float64(s.Running))
+		queueIdle.add("", labels, float64(s.Idle))
+		queuePaused.add("", labels, internal.SelectValue(s.Paused, 1.0, 0.0))
+		queueCompleted.add("", labels, float64(s.Completed))
+		queuePanics.add("", labels, float64(s.Panicked))
+		queueFailures.add("", labels, float64(s.Failed))
+		queueRetries.add("", labels, float64(s.Retried))
+		queueDeduplicated.add("", labels, float64(s.Deduplicated))
+		queueExpired.add("", labels, float64(s.Expired))
+		queueTimeouts.add("", labels, float64(s.TimedOut))
+		queueAbandoned.add("", labels, float64(s.Abandoned))
+		queueRejections.add("", []string{"queue", name, "reason", "full"}, float64(s.Rejected))
+		queueRejections.add("", []string{"queue", name, "reason", "dropped"}, float64(s.Dropped))
+		queueWait.addHistogram(labels, s.WaitLatency)
+		queueDuration.addHistogram(labels, s.ExecLatency)
+	}
+
+	var groupPending = newFamily("group_pending", "Number of tasks waiting in the group.", "gauge")
+	var groupRunning = newFamily("group_running", "Number of tasks currently running.", "gauge")
+	var groupCompleted = newFamily("group_completed_total", "Total number of tasks completed.", "counter")
+	var groupErrors = newFamily("group_errors_total", "Total number of tasks that returned an error.", "counter")
+	var groupPanics = newFamily("group_panics_total", "Total number of tasks that panicked.", "counter")
+	var groupDuration = newFamily("group_task_duration_seconds", "Time spent executing tasks.", "histogram")
+	for _, name := range sortedKeys(gs) {
+		var s = gs[name].Stats()
+		var labels = []string{"group", name}
+		groupPending.add("", labels, float64(s.Pending))
+		groupRunning.add("", labels, float64(s.Running))
+		groupCompleted.add("", labels, float64(s.Completed))
+		groupErrors.add("", labels, float64(s.Failed))
+		groupPanics.add("", labels, float64(s.Panicked))
+		groupDuration.addHistogram(labels, s.ExecLatency)
+	}
+
+	return families
+}
+
+func (c *family) add(suffix string, labels []string, value float64) {
+	c.samples = append(c.samples, sample{suffix: suffix, labels: labels, value: value})
+}
+
+// 添加直方图样本, 桶计数是累加的
+func (c *family) addHistogram(labels []string, h internal.Histogram) {
+	var sum = uint64(0)
+	for i, bound := range h.Buckets {
+		sum += h.Counts[i]
+		c.add("_bucket", append(labels[:len(labels):len(labels)], "le", formatFloat(bound.Seconds())), float64(sum))
+	}
+	c.add("_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), float64(h.Count))
+	c.add("_sum", labels, h.Sum.Seconds())
+	c.add("_count", labels, float64(h.Count))
+}
+
+func (c *family) write(w *countWriter) {
+	if len(c.samples) == 0 {
+		return
+	}
+	w.WriteString("# HELP " + c.name + " " + c.help + "\n")
+	w.WriteString("# TYPE " + c.name + " " + c.kind + "\n")
+	for _, s := range c.samples {
+		w.WriteString(c.name + s.suffix)
+		if len(s.labels) > 0 {
+			w.WriteString("{")
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				if i > 0 {
+					w.WriteString(",")
+				}
+				w.WriteString(s.labels[i] + `="` + escape(s.labels[i+1]) + `"`)
+			}
+			w.WriteString("}")
+		}
+		w.WriteString(" " + formatFloat(s.value) + "\n")
+	}
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) WriteString(s string) {
+	if c.err != nil {
+		return
+	}
+	n, err := c.w.WriteString(s)
+	c.n += int64(n)
+	c.err = err
+}
+
+var replacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 转义标签值
+func escape(s string) string {
+	return replacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lxzan/concurrency/groups"
+	"github.com/lxzan/concurrency/queues"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("queue", func(t *testing.T) {
+		q := queues.New(queues.WithSharding(2), queues.WithRecovery(), queues.WithLatencyStats())
+		q.Push(func() {})
+		q.Push(func() { panic("test") })
+		as.NoError(q.Stop(context.Background()))
+
+		r := NewRegistry("")
+		as.NoError(r.RegisterQueue("orders", q))
+		as.ErrorIs(r.RegisterQueue("orders", q), ErrDuplicateName)
+
+		var buf = bytes.NewBuffer(nil)
+		n, err := r.WriteTo(buf)
+		as.NoError(err)
+		as.Equal(int64(buf.Len()), n)
+
+		var text = buf.String()
+		as.Contains(text, "# TYPE concurrency_queue_pending gauge\n")
+		as.Contains(text, `concurrency_queue_pending{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_shard_pending{queue="orders",shard="1"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_paused{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_idle_workers{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_deduplicated_total{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_expired_total{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_timeouts_total{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_abandoned_total{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_completed_total{queue="orders"} 2`+"\n")
+		as.Contains(text, `concurrency_queue_panics_total{queue="orders"} 1`+"\n")
+		as.Contains(text, `concurrency_queue_failures_total{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_retries_total{queue="orders"} 0`+"\n")
+		as.Contains(text, `concurrency_queue_rejections_total{queue="orders",reason="full"} 0`+"\n")
+		as.Contains(text, "# TYPE concurrency_queue_job_duration_seconds histogram\n")
+		as.Contains(text, `concurrency_queue_job_duration_seconds_bucket{queue="orders",le="+Inf"} 2`+"\n")
+		as.Contains(text, `concurrency_queue_job_duration_seconds_count{queue="orders"} 2`+"\n")
+		as.Contains(text, `concurrency_queue_wait_seconds_bucket{queue="orders",le="0.0001"}`)
+		as.NotContains(text, "concurrency_group_")
+	})
+
+	t.Run("group", func(t *testing.T) {
+		g := groups.New[int]()
+		g.Push(1, 2, 3)
+		g.OnMessage = func(args int) error {
+			if args == 1 {
+				return errors.New("test")
+			}
+			return nil
+		}
+		as.Error(g.Start())
+
+		r := NewRegistry("app")
+		as.NoError(r.RegisterGroup("import", g))
+		as.ErrorIs(r.RegisterGroup("import", g), ErrDuplicateName)
+
+		var buf = bytes.NewBuffer(nil)
+		_, err := r.WriteTo(buf)
+		as.NoError(err)
+		var text = buf.String()
+		as.Contains(text, `app_group_completed_total{group="import"} 3`+"\n")
+		as.Contains(text, `app_group_errors_total{group="import"} 1`+"\n")
+		as.Contains(text, `app_group_task_duration_seconds_count{group="import"} 3`+"\n")
+		as.NotContains(text, "app_queue_")
+
+		r.Unregister("import")
+		buf.Reset()
+		_, err = r.WriteTo(buf)
+		as.NoError(err)
+		as.Equal("", buf.String())
+	})
+
+	t.Run("http handler", func(t *testing.T) {
+		q := queues.New()
+		r := NewRegistry("")
+		as.NoError(r.RegisterQueue(`a"b\c`+"\n", q))
+
+		var w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		as.Equal(http.StatusOK, w.Code)
+		as.Equal(ContentType, w.Header().Get("Content-Type"))
+		as.Contains(w.Body.String(), `concurrency_queue_pending{queue="a\"b\\c\n"} 0`)
+
+		// 每个指标族只输出一次 HELP 和 TYPE
+		as.Equal(1, strings.Count(w.Body.String(), "# TYPE concurrency_queue_running "))
+	})
+}
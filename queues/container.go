@@ -0,0 +1,280 @@
+package queues
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"time"
+
+	"github.com/lxzan/concurrency/internal"
+)
+
+type (
+	// 队列中的任务元素
+	element struct {
+		job       Job             // 任务
+		ctxJob    ContextJob      // 携带上下文的任务
+		errJob    ErrorJob        // 返回错误的任务
+		ctx       context.Context // 追加任务时的上下文
+		priority  int             // 优先级
+		seq       uint64          // 序列号
+		score     int64           // 排序分值, 越大越先执行
+		at        int64           // 预定执行时间, 仅对延迟任务有效
+		pushedAt  int64           // 进入任务队列的时间
+		startedAt int64           // 开始执行的时间
+		key       int64           // 追加任务时指定的 hashcode, 开启顺序模式时作为顺序键
+		hashed    bool            // 是否指定了 hashcode
+		keyed     bool            // 是否按顺序键串行执行
+		stealable bool            // 是否允许被其它分片窃取
+		attempt   int             // 已执行失败的次数, 大于0表示重试中的任务
+		durable   *walRecord      // 持久化任务的日志记录
+		limitKey  string          // 限制键, 开启 WithKeyLimit 时从追加任务的上下文中读取
+		unique    *uniqueJob      // 去重任务, 开始执行时从中取出最新的任务函数
+		deadline  int64           // 截止时间, 过了截止时间仍未开始执行的任务会被丢弃, 为0表示没有截止时间
+		timeout   time.Duration   // 执行超时时间, 为0表示不限制
+		onDrop    func(err error) // 任务未执行就被丢弃时的回调, 由 Submit 设置
+		next      *element        // 无锁追加时链接下一个任务
+		rejected  bool            // 无锁追加的任务因队列停止被拒绝
+		plain     bool            // 是否由先进先出容器为只有任务函数的任务创建, 执行完成后回收
+	}
+
+	// 任务容器
+	container interface {
+		Len() int
+
+		// Push 追加任务
+		Push(ele *element)
+
+		// Pop 弹出下一个要执行的任务
+		Pop() (ele *element, ok bool)
+
+		// Drop 丢弃最后才会执行的任务, 用于队列溢出
+		Drop() (ele *element, ok bool)
+
+		// Steal 弹出一个允许被窃取的任务
+		Steal() (ele *element, ok bool)
+	}
+
+	// 先进先出容器
+	fifoContainer struct {
+		stealable int // 可窃取任务数量
+		q         *internal.Ring[fifoSlot]
+		free      []*element // 回收的元素, 只有任务函数的任务弹出时取用
+	}
+
+	// 先进先出容器的槽位, 只有任务函数的任务不创建元素, 弹出时才绑定空闲元素
+	fifoSlot struct {
+		ele *element
+		job Job
+	}
+
+	// 优先级容器
+	priorityContainer struct {
+		aging     time.Duration // 老化时长
+		epoch     time.Time     // 计时起点
+		seq       uint64        // 序列号
+		stealable int           // 可窃取任务数量
+		q         elementHeap
+	}
+
+	// 按分值排序的大顶堆, 分值相同时先进先出
+	elementHeap []*element
+
+	// 任务优先级的上下文键
+	jobPriorityContext struct{}
+)
+
+// WithJobPriority 为上下文设置任务的优先级, 配合 WithPriority 使用
+// 通过 PushContext, PushWithContext, PushErrorJob, PushDurable 追加任务时, 从上下文中读取优先级
+func WithJobPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, jobPriorityContext{}, priority)
+}
+
+// 从上下文中读取任务的优先级
+func jobPriority(ctx context.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	priority, _ := ctx.Value(jobPriorityContext{}).(int)
+	return priority
+}
+
+// 先进先出容器保留的回收元素数量上限
+const maxFreeElements = 64
+
+func newContainer(o *options) container {
+	if !o.priority {
+		return &fifoContainer{q: internal.NewRing[fifoSlot](8)}
+	}
+	return newPriorityContainer(o.aging)
+}
+
+func (c *fifoContainer) Len() int { return c.q.Len() }
+
+func (c *fifoContainer) Push(ele *element) {
+	if ele.stealable {
+		c.stealable++
+	}
+	c.q.PushBack(fifoSlot{ele: ele})
+}
+
+// PushJob 追加只有任务函数的任务, 不创建元素
+func (c *fifoContainer) PushJob(job Job) {
+	c.q.PushBack(fifoSlot{job: job})
+}
+
+func (c *fifoContainer) Pop() (ele *element, ok bool) {
+	slot, ok := c.q.PopFront()
+	if !ok {
+		return ele, false
+	}
+	if ele = slot.ele; ele == nil {
+		ele = c.alloc()
+		ele.job = slot.job
+	} else if ele.stealable {
+		c.stealable--
+	}
+	return ele, true
+}
+
+// 取出一个回收的元素, 没有时创建新元素
+func (c *fifoContainer) alloc() *element {
+	var ele *element
+	if n := len(c.free); n > 0 {
+		ele, c.free = c.free[n-1], c.free[:n-1]
+	} else {
+		ele = new(element)
+	}
+	ele.plain = true
+	return ele
+}
+
+// 回收执行完成的元素, 同时执行的任务不超过并发限制, 回收的元素数量通常很少
+// 只有任务函数的任务不会重试, 也不记录时间, 元素中只有 job 需要清空
+func (c *fifoContainer) recycle(ele *element) {
+	if len(c.free) < maxFreeElements {
+		ele.job = nil
+		c.free = append(c.free, ele)
+	}
+}
+
+// Drop 丢弃最早追加的任务
+func (c *fifoContainer) Drop() (ele *element, ok bool) {
+	return c.Pop()
+}
+
+// Steal 从队尾开始查找并弹出最晚追加的可窃取任务
+func (c *fifoContainer) Steal() (ele *element, ok bool) {
+	if c.stealable == 0 {
+		return ele, false
+	}
+	for i := c.q.Len() - 1; i >= 0; i-- {
+		if slot := c.q.Get(i); slot.ele != nil && slot.ele.stealable {
+			c.stealable--
+			return c.q.Remove(i).ele, true
+		}
+	}
+	return ele, false
+}
+
+func newPriorityContainer(aging time.Duration) *priorityContainer {
+	return &priorityContainer{
+		aging: aging,
+		epoch: time.Now(),
+		q:     make(elementHeap, 0, 8),
+	}
+}
+
+func (c *priorityContainer) Len() int { return c.q.Len() }
+
+// Push 追加任务
+// 开启老化时, 任务每等待一个老化时长相当于优先级加一.
+// 由于所有任务以相同的速率老化, 比较 priority*aging - enqueueTime 即可得到相同的顺序, 无需重建堆.
+func (c *priorityContainer) Push(ele *element) {
+	c.seq++
+	ele.seq = c.seq
+	ele.score = int64(ele.priority)
+	if c.aging > 0 {
+		// 优先级限制在 ±MaxInt64/2/aging 之间, 乘以老化时长再减去经过时长都不会溢出
+		var limit = int64(math.MaxInt64/2) / int64(c.aging)
+		var priority = int64(ele.priority)
+		if priority > limit {
+			priority = limit
+		} else if priority < -limit {
+			priority = -limit
+		}
+		ele.score = priority*int64(c.aging) - int64(time.Since(c.epoch))
+	}
+	if ele.stealable {
+		c.stealable++
+	}
+	heap.Push(&c.q, ele)
+}
+
+func (c *priorityContainer) Pop() (ele *element, ok bool) {
+	if c.q.Len() == 0 {
+		return ele, false
+	}
+	if ele = heap.Pop(&c.q).(*element); ele.stealable {
+		c.stealable--
+	}
+	return ele, true
+}
+
+// Drop 丢弃排序最靠后的任务
+// 排序最靠后的元素必然是叶子节点, 只需遍历后半部分
+func (c *priorityContainer) Drop() (ele *element, ok bool) {
+	var n = c.q.Len()
+	if n == 0 {
+		return ele, false
+	}
+	var index = n / 2
+	for i := index + 1; i < n; i++ {
+		if c.q.Less(index, i) {
+			index = i
+		}
+	}
+	if ele = heap.Remove(&c.q, index).(*element); ele.stealable {
+		c.stealable--
+	}
+	return ele, true
+}
+
+// Steal 查找并弹出排序最靠前的可窃取任务
+func (c *priorityContainer) Steal() (ele *element, ok bool) {
+	if c.stealable == 0 {
+		return ele, false
+	}
+	var index = -1
+	for i := range c.q {
+		if c.q[i].stealable && (index < 0 || c.q.Less(i, index)) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return ele, false
+	}
+	c.stealable--
+	return heap.Remove(&c.q, index).(*element), true
+}
+
+func (c elementHeap) Len() int { return len(c) }
+
+func (c elementHeap) Less(i, j int) bool {
+	if c[i].score != c[j].score {
+		return c[i].score > c[j].score
+	}
+	return c[i].seq < c[j].seq
+}
+
+func (c elementHeap) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+
+func (c *elementHeap) Push(x any) { *c = append(*c, x.(*element)) }
+
+func (c *elementHeap) Pop() any {
+	var n = len(*c)
+	var ele = (*c)[n-1]
+	(*c)[n-1] = nil
+	*c = (*c)[:n-1]
+	return ele
+}
@@ -0,0 +1,272 @@
+package queues
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNotRedrivable 死信没有可以重新执行的任务
+var ErrNotRedrivable = errors.New("queues: dead letter has no job")
+
+type (
+	// DeadLetter 死信, 最终执行失败(重试耗尽或者不可重试)或者发生 panic 的任务
+	DeadLetter struct {
+		Job      any       // 原任务, 类型为 Job, ContextJob 或 ErrorJob; 从文件加载的历史死信为空
+		Name     string    // 持久化任务的处理函数名称
+		Payload  []byte    // 持久化任务的参数
+		Hashcode []int64   // 追加任务时指定的 hashcode
+		Priority int       // 优先级
+		Err      error     // 任务返回的错误, 发生 panic 时为 ErrJobPanic
+		Panic    any       // panic 的值
+		Stack    []byte    // panic 时的调用栈
+		Attempts int       // 执行次数
+		FailedAt time.Time // 最后一次执行结束的时间
+	}
+
+	// DeadLetterSink 死信接收器
+	// Put 在执行任务的协程中调用, 不应长时间阻塞
+	DeadLetterSink interface {
+		Put(letter DeadLetter) error
+	}
+
+	// MemoryDeadLetters 内存死信接收器
+	MemoryDeadLetters struct {
+		mu       sync.Mutex
+		capacity int
+		letters  []DeadLetter
+	}
+
+	// FileDeadLetters 文件死信接收器, 每行一条 JSON 格式的死信
+	// 任务函数无法持久化, 只保存在内存中, 因此只有本进程写入的死信和持久化任务的死信可以直接重新执行
+	FileDeadLetters struct {
+		mu      sync.Mutex
+		path    string
+		file    *os.File
+		offset  int   // 打开文件时已有的死信数量
+		jobs    []any // 本进程写入的死信对应的任务
+		written int   // 本进程写入的死信数量
+	}
+
+	// 死信的文件格式
+	deadLetterRecord struct {
+		Name     string    `json:"name,omitempty"`
+		Payload  []byte    `json:"payload,omitempty"`
+		Hashcode []int64   `json:"hashcode,omitempty"`
+		Priority int       `json:"priority,omitempty"`
+		Error    string    `json:"error"`
+		Panic    string    `json:"panic,omitempty"`
+		Stack    string    `json:"stack,omitempty"`
+		Attempts int       `json:"attempts"`
+		FailedAt time.Time `json:"failed_at"`
+	}
+)
+
+// WithDeadLetter 设置死信接收器
+// 最终执行失败的 ErrorJob 和发生 panic 的任务(需要开启 WithRecovery)会被发送到 sink
+func WithDeadLetter(sink DeadLetterSink) Option {
+	return func(o *options) {
+		o.deadLetter = sink
+	}
+}
+
+// Redrive 将死信重新追加到任务队列, 执行次数重新计算
+// 持久化任务的死信通过 PushDurable 追加, 从文件加载的历史死信同样可以重新执行
+// 返回所有追加失败的原因, 没有任务的死信返回 ErrNotRedrivable
+func Redrive(ctx context.Context, q Queue, letters ...DeadLetter) error {
+	var errs []error
+	for _, letter := range letters {
+		var err = pushJob(ctx, q, letter.Job, letter.Name, letter.Payload, letter.Hashcode, letter.Priority)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewMemoryDeadLetters 新建内存死信接收器
+// capacity 为最多保存的死信数量, 超出时丢弃最早的死信; 为0时不限制
+func NewMemoryDeadLetters(capacity int) *MemoryDeadLetters {
+	return &MemoryDeadLetters{capacity: capacity}
+}
+
+func (c *MemoryDeadLetters) Put(letter DeadLetter) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity > 0 && len(c.letters) >= c.capacity {
+		copy(c.letters, c.letters[1:])
+		c.letters = c.letters[:len(c.letters)-1]
+	}
+	c.letters = append(c.letters, letter)
+	return nil
+}
+
+// Len 获取死信数量
+func (c *MemoryDeadLetters) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.letters)
+}
+
+// Letters 获取所有死信
+func (c *MemoryDeadLetters) Letters() []DeadLetter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]DeadLetter(nil), c.letters...)
+}
+
+// Drain 取出并清空所有死信, 通常配合 Redrive 使用
+func (c *MemoryDeadLetters) Drain() []DeadLetter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var letters = c.letters
+	c.letters = nil
+	return letters
+}
+
+// OpenFileDeadLetters 打开文件死信接收器, 文件不存在时创建
+// 末尾不完整的行(例如写入时进程崩溃)会被截掉, 避免后续写入的死信与其拼接成无法解析的行
+func OpenFileDeadLetters(path string) (*FileDeadLetters, error) {
+	records, size, err := readDeadLetters(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() > size {
+		err = file.Truncate(size)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &FileDeadLetters{path: path, file: file, offset: len(records)}, nil
+}
+
+func (c *FileDeadLetters) Put(letter DeadLetter) error {
+	var record = deadLetterRecord{
+		Name:     letter.Name,
+		Payload:  letter.Payload,
+		Hashcode: letter.Hashcode,
+		Priority: letter.Priority,
+		Stack:    string(letter.Stack),
+		Attempts: letter.Attempts,
+		FailedAt: letter.FailedAt,
+	}
+	if letter.Err != nil {
+		record.Error = letter.Err.Error()
+	}
+	if letter.Panic != nil {
+		record.Panic = fmt.Sprint(letter.Panic)
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err = c.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	c.jobs = append(c.jobs, letter.Job)
+	c.written++
+	return nil
+}
+
+// Letters 读取文件中的所有死信
+// 本进程写入的死信携带原任务, 可以直接重新执行; 其它死信的 Job 为空, 需要调用方根据元数据设置
+func (c *FileDeadLetters) Letters() ([]DeadLetter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.letters()
+}
+
+// Drain 读取并清空文件中的所有死信, 通常配合 Redrive 使用
+func (c *FileDeadLetters) Drain() ([]DeadLetter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	letters, err := c.letters()
+	if err != nil {
+		return nil, err
+	}
+	if err = c.file.Truncate(0); err != nil {
+		return nil, err
+	}
+	c.offset, c.written, c.jobs = 0, 0, nil
+	return letters, nil
+}
+
+// Close 关闭文件
+func (c *FileDeadLetters) Close() error {
+	return c.file.Close()
+}
+
+func (c *FileDeadLetters) letters() ([]DeadLetter, error) {
+	records, _, err := readDeadLetters(c.path)
+	if err != nil {
+		return nil, err
+	}
+	var letters = make([]DeadLetter, 0, len(records))
+	for i, record := range records {
+		var letter = DeadLetter{
+			Name:     record.Name,
+			Payload:  record.Payload,
+			Hashcode: record.Hashcode,
+			Priority: record.Priority,
+			Attempts: record.Attempts,
+			FailedAt: record.FailedAt,
+		}
+		if record.Error != "" {
+			letter.Err = errors.New(record.Error)
+		}
+		if record.Panic != "" {
+			letter.Panic = record.Panic
+		}
+		if record.Stack != "" {
+			letter.Stack = []byte(record.Stack)
+		}
+		if j := i - c.offset; j >= 0 && j < c.written {
+			letter.Job = c.jobs[j]
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// 读取死信文件, 忽略末尾不完整的行, 同时返回完整的行占用的字节数
+func readDeadLetters(path string) ([]deadLetterRecord, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var records []deadLetterRecord
+	var size = int64(0)
+	var reader = bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return records, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		var record deadLetterRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return nil, 0, err
+		}
+		records = append(records, record)
+		size += int64(len(line))
+	}
+}
@@ -0,0 +1,71 @@
+package queues
+
+import (
+	"time"
+
+	"github.com/lxzan/dao/heap"
+)
+
+// 延迟任务队列
+// 所有延迟任务放在一个按到期时间排序的最小堆中, 共用一个定时器, 定时器总是指向堆顶任务的到期时间.
+// 非线程安全, 由 singleQueue 加锁调用.
+type delayQueue struct {
+	seq   uint64               // 序列号
+	at    int64                // 定时器触发时间
+	timer *time.Timer          // 定时器
+	q     *heap.Heap[*element] // 延迟任务
+}
+
+func newDelayQueue() *delayQueue {
+	return &delayQueue{
+		q: heap.NewWithWays(heap.Quadratic, func(a, b *element) bool {
+			if a.at != b.at {
+				return a.at < b.at
+			}
+			return a.seq < b.seq
+		}),
+	}
+}
+
+func (c *delayQueue) Len() int { return c.q.Len() }
+
+func (c *delayQueue) Push(ele *element) {
+	c.seq++
+	ele.seq = c.seq
+	c.q.Push(ele)
+}
+
+// PopDue 弹出一个已到期的任务
+func (c *delayQueue) PopDue(now int64) (ele *element, ok bool) {
+	if c.q.Len() == 0 || c.q.Top().at > now {
+		return ele, false
+	}
+	return c.q.Pop(), true
+}
+
+// Reset 根据堆顶任务重置定时器
+func (c *delayQueue) Reset(now int64, f func()) {
+	if c.q.Len() == 0 {
+		c.Clear()
+		return
+	}
+	var at = c.q.Top().at
+	if at == c.at {
+		return
+	}
+	c.at = at
+	if c.timer == nil {
+		c.timer = time.AfterFunc(time.Duration(at-now), f)
+	} else {
+		c.timer.Reset(time.Duration(at - now))
+	}
+}
+
+// Clear 清空延迟任务并停止定时器
+func (c *delayQueue) Clear() {
+	c.q.Reset()
+	c.at = 0
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
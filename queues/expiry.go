@@ -0,0 +1,95 @@
+package queues
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lxzan/concurrency/internal"
+)
+
+// ErrJobExpired 任务过了截止时间仍未开始执行, 被丢弃
+var ErrJobExpired = errors.New("queues: job expired")
+
+type (
+	// 任务截止时间的上下文键
+	jobDeadlineContext struct{}
+
+	// 任务存活时长的上下文键
+	jobTTLContext struct{}
+)
+
+// WithJobDeadline 为上下文设置任务的截止时间, 截止时间之前仍未开始执行的任务会被丢弃
+// 通过 PushContext, PushWithContext, PushErrorJob, PushDurable, PushUnique 追加任务时, 从上下文中读取截止时间
+// 按顺序键或者限制键积压的任务和等待令牌的任务, 在进入任务队列或者取得令牌时才检查截止时间, 等待期间不会被丢弃
+func WithJobDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, jobDeadlineContext{}, deadline)
+}
+
+// WithJobTTL 为上下文设置任务的存活时长, 从任务可以执行时开始计算, 超过存活时长仍未开始执行的任务会被丢弃
+// 用法同 WithJobDeadline, 优先级高于 WithDefaultTTL
+// 积压在顺序键或者限制键之后, 以及等待令牌的任务, 离开积压队列或者等待队列之前不会过期
+func WithJobTTL(ctx context.Context, ttl time.Duration) context.Context {
+	return context.WithValue(ctx, jobTTLContext{}, ttl)
+}
+
+// 计算任务的截止时间, 从 at 和当前时间中较晚的一个开始计算, 为0表示没有截止时间
+func (c *singleQueue) deadline(ctx context.Context, at int64, now *int64) int64 {
+	var ttl = c.conf.ttl
+	if ctx != nil {
+		if t, ok := ctx.Value(jobDeadlineContext{}).(time.Time); ok {
+			return t.UnixNano()
+		}
+		if d, ok := ctx.Value(jobTTLContext{}).(time.Duration); ok {
+			ttl = d
+		}
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	return internal.SelectValue(at > readNow(now), at, *now) + int64(ttl)
+}
+
+// 读取当前时间, now 为0时才读取时钟并保存, 同一次处理中只读取一次
+func readNow(now *int64) int64 {
+	if *now == 0 {
+		*now = time.Now().UnixNano()
+	}
+	return *now
+}
+
+// 任务是否已经过了截止时间, 没有截止时间的任务不读取时钟
+func (c *element) expired(now *int64) bool {
+	return c.deadline > 0 && c.deadline <= readNow(now)
+}
+
+// 丢弃过了截止时间的任务, 释放任务占用的顺序键和名额, 并唤醒等待空位的生产者, 调用方需持有锁
+// 需要在锁外处理的任务追加到 expired, 由 notifyExpired 处理
+func (c *singleQueue) expire(ele *element, expired []*element) []*element {
+	c.stats.expired++
+	ele.drop(ErrJobExpired)
+	c.releaseUnique(ele)
+	c.release(ele)
+	c.signal()
+	if ele.durable != nil || len(c.conf.onExpire) > 0 {
+		expired = append(expired, ele)
+	}
+	return expired
+}
+
+// 将过期的持久化任务从预写日志中移除, 然后调用任务过期的钩子
+// 在新的协程中调用, 写日志不阻塞分片, 钩子可以安全地追加任务
+func (c *singleQueue) notifyExpired(expired []*element) {
+	for _, ele := range expired {
+		if ele.durable != nil {
+			_ = c.conf.wal.done(ele.durable.ID)
+		}
+		if len(c.conf.onExpire) == 0 {
+			continue
+		}
+		var job = ele.pending()
+		for _, f := range c.conf.onExpire {
+			f(job)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package queues
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// ErrFutureCanceled 任务在开始执行前被取消
+	ErrFutureCanceled = errors.New("queues: future canceled")
+
+	// ErrJobPanic 任务执行时发生了 panic
+	ErrJobPanic = errors.New("queues: job panic")
+)
+
+// 任务被丢弃时回调的上下文键, 由 Submit 设置
+type dropHookContext struct{}
+
+const (
+	futurePending int32 = iota
+	futureRunning
+	futureCanceled
+	futureDone
+)
+
+// Future 异步任务的执行结果
+type Future[R any] struct {
+	state  atomic.Int32  // 状态
+	done   chan struct{} // 完成信号
+	result R             // 结果
+	err    error         // 错误
+}
+
+// Submit 提交一个有返回值的任务, 返回可等待结果的 Future
+// ctx 作为 PushContext 的上下文, OverflowBlock 策略下控制等待队列空位的时长, 也可以携带 WithJobTTL 等单个任务的设置
+// 任务被队列拒绝时, Future 立即完成并返回拒绝原因
+// 任务未执行就被丢弃时, Future 同样以对应的错误完成: 队列停止时丢弃或交还为 ErrQueueStopped, OverflowDropOldest 丢弃为 ErrQueueFull, 过期为 ErrJobExpired;
+// 交还的任务重新追加后不会再执行
+// 任务发生 panic 时返回 ErrJobPanic, panic 本身仍交由 Caller 处理
+func Submit[R any](ctx context.Context, q Queue, f func() (R, error), hashcode ...int64) *Future[R] {
+	var future = &Future[R]{done: make(chan struct{})}
+	var job = func() {
+		if !future.state.CompareAndSwap(futurePending, futureRunning) {
+			return
+		}
+
+		var finished = false
+		defer func() {
+			if !finished {
+				var zero R
+				future.complete(zero, ErrJobPanic)
+			}
+		}()
+
+		result, err := f()
+		finished = true
+		future.complete(result, err)
+	}
+
+	var fail = func(err error) {
+		if future.state.CompareAndSwap(futurePending, futureRunning) {
+			var zero R
+			future.complete(zero, err)
+		}
+	}
+	if err := q.PushContext(context.WithValue(ctx, dropHookContext{}, fail), job, hashcode...); err != nil {
+		fail(err)
+	}
+	return future
+}
+
+// 从上下文中读取任务被丢弃时的回调
+func dropHook(ctx context.Context) func(err error) {
+	if ctx == nil {
+		return nil
+	}
+	f, _ := ctx.Value(dropHookContext{}).(func(err error))
+	return f
+}
+
+// 任务未执行就被丢弃, 通知提交方
+func (c *element) drop(err error) {
+	if c.onDrop != nil {
+		c.onDrop(err)
+	}
+}
+
+func (c *Future[R]) complete(result R, err error) {
+	c.result, c.err = result, err
+	c.state.Store(futureDone)
+	close(c.done)
+}
+
+// Done 返回完成信号, 任务执行完成或者被取消后关闭
+func (c *Future[R]) Done() <-chan struct{} {
+	return c.done
+}
+
+// Get 等待并获取任务结果, 直到任务完成或者收到上下文信号
+func (c *Future[R]) Get(ctx context.Context) (R, error) {
+	select {
+	case <-c.done:
+		return c.result, c.err
+	case <-ctx.Done():
+		var zero R
+		return zero, ctx.Err()
+	}
+}
+
+// Cancel 取消尚未开始执行的任务, 任务已开始执行或已完成时返回 false
+func (c *Future[R]) Cancel() bool {
+	if !c.state.CompareAndSwap(futurePending, futureCanceled) {
+		return false
+	}
+	var zero R
+	c.result, c.err = zero, ErrFutureCanceled
+	close(c.done)
+	return true
+}
@@ -0,0 +1,80 @@
+package queues
+
+// 无锁追加
+// 并发已满时, 新任务只能等待正在执行的任务结束后才会被取出, 追加方没有必要与执行方争抢分片锁.
+// 此时任务通过 CAS 压入 inbox 链表后直接返回; 执行方在持有锁取任务时将 inbox 整体移入任务队列.
+//
+// 追加方压入任务后读取当前并发, 执行方修改并发后读取 inbox, 两侧均为顺序一致的原子操作,
+// 至少有一方能看到另一方的写入: 要么追加方看到空闲并发并加锁分派, 要么执行方取到新任务, 不会遗漏唤醒.
+//
+// 停止时先设置 closed 再加锁移入 inbox, 之后压入的任务在追加方再次读取 closed 时必然看到停止,
+// 这些任务由后续的移入操作标记为拒绝, 追加方加锁后据此返回 ErrQueueStopped.
+//
+// 仅对不需要在追加时检查队列状态的任务生效: 容量不限, 先进先出, 未开启限流和按键限制,
+// 且任务不是顺序任务, 可窃取任务, 去重任务或者延迟任务.
+
+// 队列的配置是否允许无锁追加
+func (o *options) lockFree() bool {
+	return o.capacity <= 0 && !o.priority && o.rate <= 0 && o.keyLimit == nil
+}
+
+// 任务是否可以无锁追加, now 为当前时间
+func (c *element) postable(now int64) bool {
+	return !c.keyed && !c.stealable && c.unique == nil && c.limitKey == "" && c.at <= now
+}
+
+// 无锁追加任务, 有空闲并发或者队列正在停止时加锁处理
+func (c *singleQueue) post(ele *element, now int64) error {
+	if c.closed.Load() {
+		return ErrQueueStopped
+	}
+	ele.at, ele.pushedAt = 0, now
+	for {
+		var head = c.inbox.Load()
+		ele.next = head
+		if c.inbox.CompareAndSwap(head, ele) {
+			break
+		}
+	}
+	if !c.closed.Load() && c.curConcurrency.Load() >= c.maxConcurrency.Load() {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.collect()
+	if ele.rejected {
+		c.mu.Unlock()
+		return ErrQueueStopped
+	}
+	var jobs = c.takeJobs(now)
+	c.mu.Unlock()
+	c.spawn(jobs)
+	return nil
+}
+
+// 将 inbox 中的任务按追加顺序移入任务队列, 调用方需持有锁
+func (c *singleQueue) collect() {
+	if c.inbox.Load() != nil {
+		c.collectInbox()
+	}
+}
+
+// 取出 inbox 中的全部任务, 停止之后压入的任务被标记为拒绝, 调用方需持有锁
+func (c *singleQueue) collectInbox() {
+	var head = c.inbox.Swap(nil)
+	var list *element
+	for head != nil {
+		var next = head.next
+		head.next, list = list, head
+		head = next
+	}
+	for list != nil {
+		var ele = list
+		list, ele.next = ele.next, nil
+		if c.stopped {
+			ele.rejected = true
+			continue
+		}
+		c.enqueue(ele)
+	}
+}
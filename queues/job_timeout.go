@@ -0,0 +1,145 @@
+package queues
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// TimedOutJob 执行超时的任务
+type TimedOutJob struct {
+	Job       any           // 原任务, 类型为 Job, ContextJob 或 ErrorJob
+	Hashcode  []int64       // 追加任务时指定的 hashcode
+	StartedAt time.Time     // 开始执行的时间
+	Timeout   time.Duration // 执行超时时间
+	Abandoned bool          // 上下文取消后超过宽限期仍未返回, 已经释放了并发槽位
+}
+
+// 任务执行超时时间的上下文键
+type jobBudgetContext struct{}
+
+// 任务的执行状态, 与任务序号一起保存在 worker.state 中
+const (
+	jobRunning   = 0 // 执行中
+	jobFinished  = 1 // 已返回
+	jobAbandoned = 2 // 超时后忽略了取消, 已经释放了并发槽位
+)
+
+// WithJobBudget 为上下文设置任务的执行超时时间, 覆盖 WithJobTimeout 的设置, 为0时不限制
+// 通过 PushContext, PushWithContext, PushErrorJob, PushDurable, PushUnique 追加任务时, 从上下文中读取
+func WithJobBudget(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, jobBudgetContext{}, timeout)
+}
+
+// 任务的执行超时时间
+func (c *singleQueue) jobTimeout(ctx context.Context) time.Duration {
+	if ctx != nil {
+		if d, ok := ctx.Value(jobBudgetContext{}).(time.Duration); ok {
+			return d
+		}
+	}
+	return c.conf.jobTimeout
+}
+
+// 开始执行任务前启动超时计时, 超时后取消任务上下文, 不限制执行时间的任务不需要计时
+func (c *worker) watch() {
+	var timeout = c.ele.timeout
+	if timeout <= 0 {
+		return
+	}
+	c.seq++
+	c.state.Store(c.seq<<2 | jobRunning)
+
+	var ctx, cancel = context.WithCancel(c.ctx)
+	var seq = c.seq
+	var report = TimedOutJob{
+		Job:       c.ele.origin(),
+		StartedAt: time.Unix(0, c.ele.startedAt),
+		Timeout:   timeout,
+	}
+	if c.ele.hashed {
+		report.Hashcode = []int64{c.ele.key}
+	}
+	c.ctx, c.cancelJob = ctx, cancel
+	c.watchdog = time.AfterFunc(timeout, func() { c.onTimeout(seq, cancel, report) })
+}
+
+// 任务返回后停止超时计时, 记录任务是否已经被放弃, 未启动计时的任务不会被放弃
+func (c *worker) unwatch() {
+	if c.watchdog == nil {
+		return
+	}
+	c.abandoned = !c.state.CompareAndSwap(c.seq<<2|jobRunning, c.seq<<2|jobFinished)
+	c.watchdog.Stop()
+	c.cancelJob()
+	c.watchdog, c.cancelJob = nil, nil
+}
+
+// 任务执行超时, 取消任务上下文, 宽限期后仍未返回则放弃该任务
+func (c *worker) onTimeout(seq uint64, cancel context.CancelFunc, report TimedOutJob) {
+	if c.state.Load() != seq<<2|jobRunning {
+		return
+	}
+	cancel()
+
+	var q = c.q
+	q.mu.Lock()
+	q.stats.timedOut++
+	q.mu.Unlock()
+	q.conf.logger.Errorf("queues: job timed out after %v", report.Timeout)
+	for _, f := range q.conf.onJobTimeout {
+		f(report)
+	}
+	time.AfterFunc(q.conf.timeoutGrace, func() { c.onAbandon(seq, report) })
+}
+
+// 任务忽略了取消, 释放其占用的并发槽位, 继续分派其它任务
+// 任务最终返回后只记录结果, 执行它的协程随即退出
+func (c *worker) onAbandon(seq uint64, report TimedOutJob) {
+	var q = c.q
+	q.mu.Lock()
+	if !c.state.CompareAndSwap(seq<<2|jobRunning, seq<<2|jobAbandoned) {
+		q.mu.Unlock()
+		return
+	}
+	report.Abandoned = true
+	q.stats.abandoned++
+	q.stuck[c] = report
+	var jobs []*element
+	if !c.inline {
+		q.curConcurrency.Add(-1)
+		jobs = q.takeJobs(time.Now().UnixNano())
+		q.checkDrained()
+	}
+	q.mu.Unlock()
+	q.spawn(jobs)
+
+	q.conf.logger.Errorf("queues: job ignored cancellation for %v after timing out, releasing its slot", q.conf.timeoutGrace)
+	for _, f := range q.conf.onJobTimeout {
+		f(report)
+	}
+}
+
+// 被放弃的任务最终返回, 记录结果
+func (c *singleQueue) settleAbandoned(w *worker) {
+	c.mu.Lock()
+	delete(c.stuck, w)
+	c.complete(w)
+	var jobs = c.takeJobs(w.end)
+	c.checkDrained()
+	c.mu.Unlock()
+	c.spawn(jobs)
+}
+
+// 忽略了取消仍在执行的任务, 按开始执行的时间排序, 调用方需持有锁
+func (c *singleQueue) stuckJobs() []TimedOutJob {
+	if len(c.stuck) == 0 {
+		return nil
+	}
+	var jobs = make([]TimedOutJob, 0, len(c.stuck))
+	for _, job := range c.stuck {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].StartedAt.Before(jobs[j].StartedAt) })
+	return jobs
+}
@@ -0,0 +1,303 @@
+package queues
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/lxzan/concurrency/internal"
+)
+
+type (
+	// KeyLimit 按键的限制, 字段为0表示不限制
+	KeyLimit struct {
+		Concurrency int     // 每个键在任务队列中或者执行中的任务数量上限
+		Rate        float64 // 每个键每秒开始执行的任务数量上限
+		Burst       int     // 每个键的突发容量, 默认为1
+	}
+
+	// 限制键的上下文键
+	limitKeyContext struct{}
+
+	// 按键限制的状态, 由 singleQueue 加锁访问
+	keyLimits struct {
+		limit      KeyLimit
+		ttl        time.Duration            // 空闲键的保留时长, 超过后令牌桶已经填满, 移除不影响限流结果
+		keys       map[string]*keyState     // 活跃键和尚未移除的空闲键
+		head, tail *keyState                // 空闲键链表, 按空闲时间排序
+		ready      internal.Ring[*keyState] // 令牌已经生成, 有任务等待开始执行的键
+	}
+
+	// 一个键的状态
+	keyState struct {
+		key        string
+		admitted   int                     // 在任务队列中或者执行中的任务数量
+		backlog    internal.Ring[*element] // 超出并发限制等待进入任务队列的任务
+		parked     internal.Ring[*element] // 已经离开任务队列, 等待令牌才能开始执行的任务
+		limiter    *rateLimiter            // 令牌桶
+		timer      Timer                   // 等待令牌的定时器
+		ready      bool                    // 是否在令牌已经生成的键列表中
+		idle       bool                    // 是否在空闲键链表中
+		idleAt     time.Time               // 开始空闲的时间
+		prev, next *keyState
+	}
+)
+
+// WithLimitKey 为上下文设置限制键, 配合 WithKeyLimit 使用
+// 通过 PushContext, PushWithContext, PushErrorJob, PushDurable 追加任务时, 从上下文中读取限制键
+func WithLimitKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, limitKeyContext{}, key)
+}
+
+// 从上下文中读取限制键
+func limitKey(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	key, _ := ctx.Value(limitKeyContext{}).(string)
+	return key
+}
+
+// 根据限制键选择分片
+func hashKey(key string) int64 {
+	var h = fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return int64(h.Sum64() & (1<<63 - 1))
+}
+
+func newKeyLimits(limit KeyLimit) *keyLimits {
+	var c = &keyLimits{limit: limit, keys: make(map[string]*keyState)}
+	if limit.Rate > 0 {
+		c.ttl = time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second))
+	}
+	return c
+}
+
+// 移除所有键, 原地重置, 使 singleQueue.keys 创建后不再改变
+func (c *keyLimits) reset() {
+	c.keys = make(map[string]*keyState)
+	c.head, c.tail = nil, nil
+	c.ready = internal.Ring[*keyState]{}
+}
+
+// 获取键的状态, 不存在时创建; 同时移除过期的空闲键
+func (c *keyLimits) get(key string, clock Clock) *keyState {
+	var now = clock.Now()
+	c.sweep(now)
+	if st, ok := c.keys[key]; ok {
+		c.unlink(st)
+		return st
+	}
+	var st = &keyState{key: key}
+	if c.limit.Rate > 0 {
+		st.limiter = newRateLimiter(c.limit.Rate, c.limit.Burst, clock)
+	}
+	c.keys[key] = st
+	return st
+}
+
+// 检查键是否可以再放入一个任务
+func (c *keyLimits) allow(st *keyState) bool {
+	return c.limit.Concurrency <= 0 || st.admitted < c.limit.Concurrency
+}
+
+// 键没有任务时放入空闲键链表
+func (c *keyLimits) release(st *keyState, now time.Time) {
+	if st.admitted > 0 || st.backlog.Len() > 0 || st.timer != nil || st.idle {
+		return
+	}
+	if c.ttl <= 0 {
+		delete(c.keys, st.key)
+		return
+	}
+	st.idle, st.idleAt = true, now
+	st.prev, st.next = c.tail, nil
+	if c.tail != nil {
+		c.tail.next = st
+	} else {
+		c.head = st
+	}
+	c.tail = st
+}
+
+// 从空闲键链表中移除
+func (c *keyLimits) unlink(st *keyState) {
+	if !st.idle {
+		return
+	}
+	if st.prev != nil {
+		st.prev.next = st.next
+	} else {
+		c.head = st.next
+	}
+	if st.next != nil {
+		st.next.prev = st.prev
+	} else {
+		c.tail = st.prev
+	}
+	st.idle, st.prev, st.next = false, nil, nil
+}
+
+// 移除空闲超过保留时长的键
+func (c *keyLimits) sweep(now time.Time) {
+	for c.head != nil && now.Sub(c.head.idleAt) >= c.ttl {
+		var st = c.head
+		c.unlink(st)
+		delete(c.keys, st.key)
+	}
+}
+
+// 将任务放入任务队列, 超出限制键的限制时放入该键的积压队列, 调用方需持有锁
+// 重试中的任务继续占用键的名额, 直接放入任务队列
+func (c *singleQueue) admit(ele *element) {
+	if ele.limitKey == "" || ele.attempt > 0 || c.keys == nil {
+		c.q.Push(ele)
+		return
+	}
+	var st = c.keys.get(ele.limitKey, c.conf.clock)
+	st.backlog.PushBack(ele)
+	c.backlog++
+	c.admitBacklog(st)
+}
+
+// 按键的并发限制将积压的任务放入任务队列, 调用方需持有锁
+func (c *singleQueue) admitBacklog(st *keyState) {
+	for st.backlog.Len() > 0 && c.keys.allow(st) {
+		ele, _ := st.backlog.PopFront()
+		c.backlog--
+		st.admitted++
+		c.q.Push(ele)
+	}
+}
+
+// 检查任务是否可以开始执行, 可以时占用键的令牌, 调用方需持有锁
+// 令牌不足, 或者同一个键有更早的任务在等待令牌时, 任务暂存到该键的等待队列, 令牌生成后优先执行
+func (c *singleQueue) pace(ele *element) bool {
+	if ele.limitKey == "" || c.keys == nil || c.keys.limit.Rate <= 0 {
+		return true
+	}
+	var st = c.keys.get(ele.limitKey, c.conf.clock)
+	if st.parked.Len() == 0 {
+		d, ok := st.limiter.take()
+		if ok {
+			return true
+		}
+		c.awaitToken(st, d)
+	}
+	st.parked.PushBack(ele)
+	c.parked++
+	return false
+}
+
+// 等待键的下一个令牌, 调用方需持有锁
+func (c *singleQueue) awaitToken(st *keyState, d time.Duration) {
+	if st.timer == nil {
+		st.timer = c.conf.clock.AfterFunc(d, func() { c.onKeyToken(st) })
+	}
+}
+
+// 取出一个令牌已经生成的等待任务, 调用方需持有锁
+// 过了截止时间的任务不占用令牌, 直接返回由调用方丢弃
+func (c *singleQueue) unpark(now *int64) (*element, bool) {
+	for c.keys != nil && c.keys.ready.Len() > 0 {
+		var st = *c.keys.ready.Get(0)
+		if st.parked.Len() > 0 {
+			if (*st.parked.Get(0)).expired(now) {
+				return c.popParked(st), true
+			}
+			d, ok := st.limiter.take()
+			if ok {
+				return c.popParked(st), true
+			}
+			c.awaitToken(st, d)
+		}
+		c.keys.ready.PopFront()
+		st.ready = false
+	}
+	return nil, false
+}
+
+// 弹出键的第一个等待任务, 等待队列为空时移出令牌已经生成的键列表, 调用方需持有锁
+func (c *singleQueue) popParked(st *keyState) *element {
+	ele, _ := st.parked.PopFront()
+	c.parked--
+	if st.parked.Len() == 0 && st.ready {
+		c.keys.ready.PopFront()
+		st.ready = false
+	}
+	return ele
+}
+
+// 键的令牌定时器回调, 执行等待令牌的任务
+func (c *singleQueue) onKeyToken(st *keyState) {
+	c.mu.Lock()
+	st.timer = nil
+	var jobs []*element
+	if c.keys.keys[st.key] == st {
+		if st.parked.Len() > 0 && !st.ready {
+			st.ready = true
+			c.keys.ready.PushBack(st)
+		}
+		jobs = c.takeJobs(time.Now().UnixNano())
+	}
+	c.mu.Unlock()
+
+	c.spawn(jobs)
+}
+
+// 任务执行完成或者被丢弃后归还键的名额, 调用方需持有锁
+func (c *singleQueue) releaseKey(ele *element) {
+	if ele.limitKey == "" || c.keys == nil {
+		return
+	}
+	var st = c.keys.keys[ele.limitKey]
+	if st == nil || st.admitted == 0 {
+		return
+	}
+	st.admitted--
+	c.admitBacklog(st)
+	c.keys.release(st, c.conf.clock.Now())
+}
+
+// 调用方协程执行任务前检查键的限制, 通过时占用名额, 调用方需持有锁
+func (c *singleQueue) tryAdmit(ele *element) bool {
+	if ele.limitKey == "" || c.keys == nil {
+		return true
+	}
+	var st = c.keys.get(ele.limitKey, c.conf.clock)
+	if st.backlog.Len() == 0 && st.parked.Len() == 0 && c.keys.allow(st) {
+		if st.limiter == nil {
+			st.admitted++
+			return true
+		}
+		if _, ok := st.limiter.take(); ok {
+			st.admitted++
+			return true
+		}
+	}
+	c.keys.release(st, c.conf.clock.Now())
+	return false
+}
+
+// 取出所有键积压和等待令牌的任务, 并重置按键限制的状态, 调用方需持有锁
+func (c *singleQueue) clearKeys(eles []*element) []*element {
+	if c.keys == nil {
+		return eles
+	}
+	for _, st := range c.keys.keys {
+		for st.parked.Len() > 0 {
+			ele, _ := st.parked.PopFront()
+			eles = append(eles, ele)
+		}
+		for st.backlog.Len() > 0 {
+			ele, _ := st.backlog.PopFront()
+			eles = append(eles, ele)
+		}
+		if st.timer != nil {
+			st.timer.Stop()
+		}
+	}
+	c.keys.reset()
+	c.parked = 0
+	return eles
+}
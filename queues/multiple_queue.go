@@ -2,8 +2,8 @@ package queues
 
 import (
 	"context"
-	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type (
@@ -12,8 +12,6 @@ type (
 		serial atomic.Int64   // 序列号
 		qs     []*singleQueue // 子队列
 	}
-
-	errWrapper struct{ err error }
 )
 
 // 创建多重队列
@@ -22,6 +20,11 @@ func newMultipleQueue(o *options) *multipleQueue {
 	for i := int64(0); i < o.sharding; i++ {
 		qs[i] = newSingleQueue(o)
 	}
+	if o.workStealing {
+		for _, q := range qs {
+			q.siblings = qs
+		}
+	}
 	return &multipleQueue{conf: o, qs: qs}
 }
 
@@ -33,32 +36,153 @@ func (c *multipleQueue) Len() int {
 	return sum
 }
 
-// Push 追加任务
-func (c *multipleQueue) Push(job Job, hashcode ...int64) {
+// 根据 hashcode 选择分片, 未指定时轮询
+func (c *multipleQueue) route(hashcode []int64) *singleQueue {
 	var index = int64(0)
 	if len(hashcode) == 0 {
 		index = c.serial.Add(1) & (c.conf.sharding - 1)
 	} else {
 		index = hashcode[0] & (c.conf.sharding - 1)
 	}
-	c.qs[index].Push(job)
+	return c.qs[index]
+}
+
+// 根据 hashcode 选择分片, 未指定 hashcode 时优先按上下文中的限制键选择分片
+func (c *multipleQueue) routeContext(ctx context.Context, hashcode []int64) *singleQueue {
+	if len(hashcode) == 0 && c.conf.keyLimit != nil {
+		if key := limitKey(ctx); key != "" {
+			return c.qs[hashKey(key)&(c.conf.sharding-1)]
+		}
+	}
+	return c.route(hashcode)
+}
+
+// Push 追加任务
+func (c *multipleQueue) Push(job Job, hashcode ...int64) {
+	c.route(hashcode).Push(job, hashcode...)
+}
+
+// PushPriority 追加带优先级的任务
+func (c *multipleQueue) PushPriority(job Job, priority int, hashcode ...int64) {
+	c.route(hashcode).PushPriority(job, priority, hashcode...)
+}
+
+// PushContext 追加任务, 返回任务被拒绝的原因
+func (c *multipleQueue) PushContext(ctx context.Context, job Job, hashcode ...int64) error {
+	return c.routeContext(ctx, hashcode).PushContext(ctx, job, hashcode...)
+}
+
+// TryPush 尝试追加任务, 不会阻塞
+func (c *multipleQueue) TryPush(job Job, hashcode ...int64) bool {
+	return c.route(hashcode).TryPush(job, hashcode...)
+}
+
+// PushContextJob 追加携带上下文的任务
+func (c *multipleQueue) PushContextJob(job ContextJob, hashcode ...int64) {
+	c.route(hashcode).PushContextJob(job, hashcode...)
+}
+
+// PushWithContext 追加携带上下文的任务, 返回任务被拒绝的原因
+func (c *multipleQueue) PushWithContext(ctx context.Context, job ContextJob, hashcode ...int64) error {
+	return c.routeContext(ctx, hashcode).PushWithContext(ctx, job, hashcode...)
+}
+
+// PushErrorJob 追加返回错误的任务, 返回任务被拒绝的原因
+func (c *multipleQueue) PushErrorJob(ctx context.Context, job ErrorJob, hashcode ...int64) error {
+	return c.routeContext(ctx, hashcode).PushErrorJob(ctx, job, hashcode...)
+}
+
+// PushDurable 追加持久化任务, 返回任务被拒绝的原因
+func (c *multipleQueue) PushDurable(ctx context.Context, name string, payload []byte, hashcode ...int64) error {
+	return c.routeContext(ctx, hashcode).PushDurable(ctx, name, payload, hashcode...)
+}
+
+// PushUnique 追加去重任务, 未指定 hashcode 时优先按上下文中的限制键选择分片, 其次按 key 选择分片
+// 去重只在分片内生效, 开启 WithKeyLimit 时相同 key 的任务应使用相同的限制键
+func (c *multipleQueue) PushUnique(ctx context.Context, key string, job Job, hashcode ...int64) error {
+	if len(hashcode) == 0 && (c.conf.keyLimit == nil || limitKey(ctx) == "") {
+		return c.qs[hashKey(key)&(c.conf.sharding-1)].PushUnique(ctx, key, job)
+	}
+	return c.routeContext(ctx, hashcode).PushUnique(ctx, key, job, hashcode...)
+}
+
+// PushAfter 追加延迟任务
+func (c *multipleQueue) PushAfter(job Job, d time.Duration, hashcode ...int64) {
+	c.route(hashcode).PushAfter(job, d, hashcode...)
+}
+
+// PushAt 追加定时任务
+func (c *multipleQueue) PushAt(job Job, t time.Time, hashcode ...int64) {
+	c.route(hashcode).PushAt(job, t, hashcode...)
+}
+
+// SetConcurrency 设置每个分片的最大并发, n 为0时忽略
+func (c *multipleQueue) SetConcurrency(n uint32) {
+	for _, q := range c.qs {
+		q.SetConcurrency(n)
+	}
+}
+
+// Pause 暂停所有分片分派任务
+func (c *multipleQueue) Pause() {
+	for _, q := range c.qs {
+		q.Pause()
+	}
+}
+
+// Resume 恢复所有分片分派任务
+func (c *multipleQueue) Resume() {
+	for _, q := range c.qs {
+		q.Resume()
+	}
+}
+
+// Concurrency 获取每个分片的最大并发
+func (c *multipleQueue) Concurrency() uint32 {
+	return c.qs[0].Concurrency()
+}
+
+// Stats 获取统计快照, 包含聚合统计和各个分片的统计
+func (c *multipleQueue) Stats() Stats {
+	var s = Stats{Shards: make([]Stats, 0, len(c.qs))}
+	for _, q := range c.qs {
+		s.merge(q.shardStats())
+	}
+	return s
 }
 
 // Stop 停止
 // 可能需要等待一段时间, 直到所有任务执行完成或者超时
 func (c *multipleQueue) Stop(ctx context.Context) error {
-	var err = atomic.Pointer[errWrapper]{}
-	var wg = sync.WaitGroup{}
-	wg.Add(int(c.conf.sharding))
-	for i, _ := range c.qs {
-		go func(q *singleQueue) {
-			err.CompareAndSwap(nil, &errWrapper{q.Stop(ctx)})
-			wg.Done()
-		}(c.qs[i])
+	_, err := c.Shutdown(ctx, StopDrain)
+	return err
+}
+
+// Shutdown 按指定方式停止所有分片, 返回所有分片的聚合结果
+// 先停止所有分片, 再在同一个超时时间内依次等待各个分片完成
+func (c *multipleQueue) Shutdown(ctx context.Context, mode StopMode) (StopResult, error) {
+	var sts = make([]*stopping, len(c.qs))
+	for i, q := range c.qs {
+		sts[i], _ = q.stop(mode)
+	}
+
+	ctx1, cancel := context.WithTimeout(ctx, c.conf.timeout)
+	defer cancel()
+
+	var err error
+	var result StopResult
+	for i, q := range c.qs {
+		if sts[i] == nil {
+			continue
+		}
+		if e := q.wait(ctx1, sts[i]); e != nil && err == nil {
+			err = e
+		}
 	}
-	wg.Wait()
-	if e := err.Load(); e != nil {
-		return e.err
+	for i, q := range c.qs {
+		if sts[i] != nil {
+			result.merge(q.settle(sts[i]))
+		}
 	}
-	return nil
+	return result, err
 }
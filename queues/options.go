@@ -3,6 +3,7 @@ package queues
 import (
 	"github.com/lxzan/concurrency/internal"
 	"github.com/lxzan/concurrency/logs"
+	"github.com/lxzan/concurrency/tracing"
 	"runtime"
 	"time"
 	"unsafe"
@@ -14,6 +15,38 @@ type options struct {
 	timeout     time.Duration // 退出等待超时时间
 	caller      Caller        // 调用器
 	logger      logs.Logger   // 日志组件
+	priority    bool          // 是否开启优先级模式
+	aging       time.Duration // 优先级老化时长
+
+	discardDelayed bool               // 停止时丢弃未到期的延迟任务
+	capacity       int                // 每个分片的容量
+	overflow       OverflowPolicy     // 溢出策略
+	keyedSerial    bool               // 是否按 hashcode 串行执行
+	workStealing   bool               // 是否开启分片间任务窃取
+	tracer         tracing.Tracer     // 链路追踪
+	retry          *RetryPolicy       // 重试策略
+	deadLetter     DeadLetterSink     // 死信接收器
+	wal            *WAL               // 预写日志
+	handlers       map[string]Handler // 持久化任务的处理函数
+	workerPool     bool               // 是否开启常驻协程池
+	idleTimeout    time.Duration      // 常驻协程的空闲超时时间
+	rate           float64            // 每秒分派的任务数量, 为0时不限流
+	burst          int                // 限流的突发容量
+	limiter        *rateLimiter       // 所有分片共享的限流器, 按分片限流时为空
+	shardLimit     bool               // 是否按分片限流
+	clock          Clock              // 时钟
+	keyLimit       *KeyLimit          // 按键的限制
+	unique         UniquePolicy       // 去重策略
+	ttl            time.Duration      // 任务的默认存活时长, 为0时不过期
+	jobTimeout     time.Duration      // 任务的执行超时时间, 为0时不限制
+	timeoutGrace   time.Duration      // 执行超时后等待任务返回的宽限期
+	latency        bool               // 是否统计排队等待时长和执行时长分布
+
+	onJobStart   []func(wait time.Duration)      // 任务开始执行的钩子
+	onJobFinish  []func(elapsed time.Duration)   // 任务执行结束的钩子
+	onPanic      []func(value any, stack []byte) // 任务发生 panic 的钩子
+	onExpire     []func(job PendingJob)          // 任务过期的钩子
+	onJobTimeout []func(job TimedOutJob)         // 任务执行超时的钩子
 }
 
 type Option func(o *options)
@@ -39,6 +72,180 @@ func WithTimeout(t time.Duration) Option {
 	}
 }
 
+// WithPriority 开启优先级模式, 优先级高的任务先执行, 相同优先级先进先出
+// aging 为老化时长, 任务每等待一个 aging 相当于优先级加一, 避免低优先级任务饿死; 为0时不老化
+func WithPriority(aging time.Duration) Option {
+	return func(o *options) {
+		o.priority = true
+		o.aging = aging
+	}
+}
+
+// WithDiscardDelayed 停止时丢弃未到期的延迟任务
+// 默认情况下, Stop 会等待延迟任务到期并执行, 直到超时为止
+func WithDiscardDelayed() Option {
+	return func(o *options) {
+		o.discardDelayed = true
+	}
+}
+
+// WithCapacity 设置每个分片的容量(包含未到期的延迟任务), 默认为0, 表示不限制
+func WithCapacity(n uint32) Option {
+	return func(o *options) {
+		o.capacity = int(n)
+	}
+}
+
+// WithOverflowPolicy 设置队列已满时的溢出策略, 默认为 OverflowBlock
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.overflow = policy
+	}
+}
+
+// WithKeyedSerial 开启顺序模式, 指定了相同 hashcode 的任务严格按照追加顺序串行执行
+// 不同 hashcode 的任务仍然可以在同一分片内并行执行, 未指定 hashcode 的任务不受影响
+func WithKeyedSerial() Option {
+	return func(o *options) {
+		o.keyedSerial = true
+	}
+}
+
+// WithWorkStealing 开启分片间任务窃取(仅对多队列有效)
+// 空闲的分片会从繁忙的分片窃取未指定 hashcode 的任务, 指定了 hashcode 的任务始终在其路由到的分片执行
+func WithWorkStealing() Option {
+	return func(o *options) {
+		o.workStealing = true
+	}
+}
+
+// WithWorkerPool 开启常驻协程池, 执行完任务的协程挂起等待新任务, 而不是退出后再为新任务创建协程
+// 协程空闲超过 idleTimeout 后退出, 为0时不退出, 直到队列停止; 协程数量不超过最大并发
+func WithWorkerPool(idleTimeout time.Duration) Option {
+	return func(o *options) {
+		o.workerPool = true
+		o.idleTimeout = idleTimeout
+	}
+}
+
+// WithRateLimit 限制任务的分派速率, 每秒最多分派 rate 个任务, 允许突发分派 burst 个任务
+// 限流与并发限制相互独立, 等待令牌的任务留在队列中, 不占用并发槽位; 多队列模式下所有分片共享同一个限流器
+func WithRateLimit(rate float64, burst int) Option {
+	return func(o *options) {
+		o.rate = rate
+		o.burst = burst
+		o.shardLimit = false
+	}
+}
+
+// WithShardRateLimit 与 WithRateLimit 相同, 但是每个分片使用独立的限流器, 总速率为 rate 乘以分片数量
+func WithShardRateLimit(rate float64, burst int) Option {
+	return func(o *options) {
+		o.rate = rate
+		o.burst = burst
+		o.shardLimit = true
+	}
+}
+
+// WithClock 设置限流器使用的时钟, 默认为系统时钟, 用于测试
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithKeyLimit 开启按键限制, 通过 WithLimitKey 为追加任务的上下文设置限制键, 同一个键的任务受 limit 的并发和速率限制
+// 超出限制的任务按追加顺序暂存在该键的积压队列, 不影响其它键的任务; 空闲的键在令牌桶填满后自动移除, 内存占用与活跃键的数量成正比
+// 多队列模式下, 未指定 hashcode 的任务按限制键选择分片, 指定了 hashcode 时限制只在分片内生效
+func WithKeyLimit(limit KeyLimit) Option {
+	return func(o *options) {
+		limit.Burst = internal.SelectValue(limit.Burst <= 0, 1, limit.Burst)
+		o.keyLimit = &limit
+	}
+}
+
+// WithTracer 开启链路追踪, 为每个任务创建 span, 记录排队等待时长, 执行时长和 panic
+// span 作为追加任务时上下文中的 span 的子 span, 携带上下文的任务可以从任务上下文中获取该 span
+func WithTracer(tracer tracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithOnJobStart 添加任务开始执行的钩子, wait 为任务的排队等待时长
+// 可以多次调用添加多个钩子, 按添加顺序依次调用; 钩子在 Caller 之外调用, 不应阻塞或者 panic
+func WithOnJobStart(f func(wait time.Duration)) Option {
+	return func(o *options) {
+		o.onJobStart = append(o.onJobStart, f)
+	}
+}
+
+// WithOnJobFinish 添加任务执行结束的钩子, elapsed 为任务的执行时长, 发生 panic 的任务同样会调用
+// 可以多次调用添加多个钩子, 按添加顺序依次调用; 钩子在 Caller 之外调用, 不应阻塞或者 panic
+func WithOnJobFinish(f func(elapsed time.Duration)) Option {
+	return func(o *options) {
+		o.onJobFinish = append(o.onJobFinish, f)
+	}
+}
+
+// WithOnPanic 添加任务发生 panic 的钩子, value 为 panic 的值, stack 为调用栈
+// 钩子调用后 panic 会继续交由 Caller 处理, 未开启 WithRecovery 时程序仍然会崩溃
+// 可以多次调用添加多个钩子, 按添加顺序依次调用
+func WithOnPanic(f func(value any, stack []byte)) Option {
+	return func(o *options) {
+		o.onPanic = append(o.onPanic, f)
+	}
+}
+
+// WithDefaultTTL 设置任务的默认存活时长, 从任务可以执行时开始计算, 超过存活时长仍未开始执行的任务会被丢弃
+// 重试的任务从重试时间开始重新计算; 可以通过 WithJobTTL, WithJobDeadline 为单个任务设置; 为0时不过期
+// 注意: 同一个顺序键的后续任务, 超出 WithKeyLimit 限制的积压任务和等待令牌的任务只在轮到它们时检查是否过期, 不会在等待期间被丢弃
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithOnExpire 添加任务过期的钩子, 过了截止时间仍未开始执行的任务被丢弃后调用
+// 钩子在新的协程中调用, 可以安全地追加任务; 可以多次调用添加多个钩子, 按添加顺序依次调用
+func WithOnExpire(f func(job PendingJob)) Option {
+	return func(o *options) {
+		o.onExpire = append(o.onExpire, f)
+	}
+}
+
+// WithJobTimeout 设置任务的执行超时时间, 超时后取消任务上下文(携带上下文的任务可以感知), 并通过日志和钩子报告
+// 超时后超过宽限期仍未返回的任务被放弃: 释放其占用的并发槽位, 计入 Stats().Stuck, 直到任务最终返回
+// 可以通过 WithJobBudget 为单个任务设置; 为0时不限制
+func WithJobTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.jobTimeout = d
+	}
+}
+
+// WithTimeoutGrace 设置执行超时后等待任务返回的宽限期, 默认为1s
+func WithTimeoutGrace(d time.Duration) Option {
+	return func(o *options) {
+		o.timeoutGrace = d
+	}
+}
+
+// WithOnJobTimeout 添加任务执行超时的钩子, 超时时调用一次, 超过宽限期仍未返回时以 Abandoned 为 true 再调用一次
+// 钩子在定时器协程中调用; 可以多次调用添加多个钩子, 按添加顺序依次调用
+func WithOnJobTimeout(f func(job TimedOutJob)) Option {
+	return func(o *options) {
+		o.onJobTimeout = append(o.onJobTimeout, f)
+	}
+}
+
+// WithLatencyStats 开启时长统计, 记录每个任务的排队等待时长和执行时长, 通过 Stats().WaitLatency, Stats().ExecLatency 查看
+// 默认关闭, 未开启时间相关的功能时, 执行任务不需要读取时钟
+func WithLatencyStats() Option {
+	return func(o *options) {
+		o.latency = true
+	}
+}
+
 // WithLogger 设置日志组件
 func WithLogger(logger logs.Logger) Option {
 	return func(o *options) {
@@ -46,6 +253,11 @@ func WithLogger(logger logs.Logger) Option {
 	}
 }
 
+// 是否需要记录每个任务进入任务队列, 开始执行和执行结束的时间
+func (o *options) timed() bool {
+	return o.latency || o.tracer != nil || o.jobTimeout > 0 || len(o.onJobStart) > 0 || len(o.onJobFinish) > 0
+}
+
 func withInitialize() Option {
 	return func(o *options) {
 		o.sharding = internal.SelectValue(o.sharding <= 0, defaultSharding, o.sharding)
@@ -54,6 +266,12 @@ func withInitialize() Option {
 		o.timeout = internal.SelectValue(o.timeout <= 0, defaultTimeout, o.timeout)
 		o.logger = internal.SelectValue[logs.Logger](o.logger == nil, logs.DefaultLogger, o.logger)
 		o.caller = internal.SelectValue(o.caller == nil, defaultCaller, o.caller)
+		o.clock = internal.SelectValue[Clock](o.clock == nil, systemClock{}, o.clock)
+		o.burst = internal.SelectValue(o.burst <= 0, 1, o.burst)
+		o.timeoutGrace = internal.SelectValue(o.timeoutGrace <= 0, defaultTimeoutGrace, o.timeoutGrace)
+		if o.rate > 0 && !o.shardLimit {
+			o.limiter = newRateLimiter(o.rate, o.burst, o.clock)
+		}
 	}
 }
 
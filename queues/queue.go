@@ -2,6 +2,7 @@ package queues
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/lxzan/concurrency/logs"
@@ -9,10 +10,44 @@ import (
 
 var defaultCaller Caller = func(logger logs.Logger, f func()) { f() }
 
+var (
+	// ErrQueueFull 队列已满, 任务被拒绝
+	ErrQueueFull = errors.New("queues: queue is full")
+
+	// ErrQueueStopped 队列已停止, 任务被拒绝
+	ErrQueueStopped = errors.New("queues: queue is stopped")
+)
+
+// OverflowPolicy 队列已满时的溢出策略
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock 阻塞等待空位; TryPush 不会等待, 直接拒绝
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowReject 拒绝新任务
+	OverflowReject
+
+	// OverflowDropOldest 丢弃队列中最后才会执行的任务(先进先出模式下即最早的任务), 接受新任务
+	// 队列中只有未到期的延迟任务时拒绝新任务
+	OverflowDropOldest
+
+	// OverflowCallerRuns 在调用方协程中直接执行新任务
+	// 顺序模式下, 如果同一 hashcode 还有未完成的任务, 为保证顺序会拒绝新任务
+	OverflowCallerRuns
+)
+
 const (
-	defaultSharding    = 1
-	defaultConcurrency = 8
-	defaultTimeout     = 30 * time.Second
+	defaultSharding     = 1
+	defaultConcurrency  = 8
+	defaultTimeout      = 30 * time.Second
+	defaultTimeoutGrace = time.Second // 默认执行超时后等待任务返回的宽限期
+
+	defaultInitialBackoff = 100 * time.Millisecond // 默认首次重试等待时长
+	defaultMultiplier     = 2                      // 默认重试等待时长增长倍数
+	maxBackoff            = 24 * time.Hour         // 重试等待时长的上限
+
+	spanName = "queues.job" // 任务 span 的名称
 )
 
 type (
@@ -20,17 +55,86 @@ type (
 
 	Job func()
 
+	// ContextJob 携带上下文的任务
+	// 上下文在队列停止等待超时后取消, 长时间运行的任务可以据此及时退出
+	ContextJob func(ctx context.Context)
+
+	// ErrorJob 返回错误的任务, 上下文同 ContextJob
+	// 返回错误时按 WithRetry 设置的策略重试, 最终失败的任务计入统计并记录日志
+	ErrorJob func(ctx context.Context) error
+
 	Queue interface {
 		// Len 获取队列中剩余任务数量
 		Len() int
 
 		// Push 追加任务
 		// hashcode 可选参数，用于指定任务路由到的分片（仅对多队列有效）
+		// 开启 WithKeyedSerial 时, hashcode 同时作为顺序键, 相同 hashcode 的任务串行执行
 		Push(job Job, hashcode ...int64)
 
-		// Stop 停止
+		// PushPriority 追加带优先级的任务, 数值越大越先执行
+		// 仅在开启 WithPriority 时生效, 否则等同于 Push
+		PushPriority(job Job, priority int, hashcode ...int64)
+
+		// PushContextJob 追加携带上下文的任务
+		// 任务上下文在 Stop 等待超时(收到上下文信号或超过 WithTimeout)后取消
+		PushContextJob(job ContextJob, hashcode ...int64)
+
+		// PushWithContext 追加携带上下文的任务, 返回任务被拒绝的原因
+		// ctx 用于等待空位(同 PushContext), 其中的值(例如链路追踪信息)会传递给任务上下文, 取消信号不会传递
+		PushWithContext(ctx context.Context, job ContextJob, hashcode ...int64) error
+
+		// PushErrorJob 追加返回错误的任务, 返回任务被拒绝的原因, ctx 的用法同 PushWithContext
+		PushErrorJob(ctx context.Context, job ErrorJob, hashcode ...int64) error
+
+		// PushDurable 追加持久化任务, 返回任务被拒绝的原因, 需要开启 WithWAL
+		// 任务写入预写日志并刷盘之后才返回, name 为 WithWAL 中注册的处理函数名称, payload 为处理函数的参数
+		PushDurable(ctx context.Context, name string, payload []byte, hashcode ...int64) error
+
+		// PushUnique 追加去重任务, 返回任务被拒绝的原因
+		// 相同 key 的任务正在等待执行时, 按 WithUniquePolicy 丢弃新任务或者替换等待中的任务; 任务开始执行后释放 key
+		// 多队列模式下, 未指定 hashcode 的任务按 key 选择分片
+		PushUnique(ctx context.Context, key string, job Job, hashcode ...int64) error
+
+		// PushAfter 追加延迟任务, 等待 d 之后才能执行
+		PushAfter(job Job, d time.Duration, hashcode ...int64)
+
+		// PushAt 追加定时任务, 到达 t 之后才能执行
+		// 未到期的任务同样计入 Len, 并受 WithConcurrency 限制
+		PushAt(job Job, t time.Time, hashcode ...int64)
+
+		// PushContext 追加任务, 返回任务被拒绝的原因
+		// 开启 WithCapacity 且队列已满时按溢出策略处理, 阻塞策略下等待空位直到上下文结束
+		// 开启 WithTracer 时, 任务的 span 作为 ctx 中的 span 的子 span
+		PushContext(ctx context.Context, job Job, hashcode ...int64) error
+
+		// TryPush 尝试追加任务, 不会阻塞, 任务被拒绝时返回 false
+		TryPush(job Job, hashcode ...int64) bool
+
+		// SetConcurrency 设置(每个分片的)最大并发, n 为0时忽略
+		// 调低时正在执行的任务不受影响, 只是不再启动新任务直到并发低于新的限制; 调高时立即执行排队中的任务
+		SetConcurrency(n uint32)
+
+		// Pause 暂停分派任务, 仍然可以追加任务, 正在执行的任务不受影响
+		// 暂停期间队列已满时, OverflowCallerRuns 策略会拒绝新任务; 暂停状态下 Stop 会等待恢复或者超时
+		Pause()
+
+		// Resume 恢复分派任务, 立即执行排队中的任务
+		Resume()
+
+		// Concurrency 获取(每个分片的)最大并发
+		Concurrency() uint32
+
+		// Stats 获取统计快照, 包含聚合统计和各个分片的统计
+		Stats() Stats
+
+		// Stop 停止, 等同于 StopDrain 方式的 Shutdown
 		// 停止后不能追加新的任务, 队列中剩余的任务会继续执行, 到收到上下文信号为止.
 		Stop(ctx context.Context) error
+
+		// Shutdown 按指定方式停止, 返回停止期间完成, 丢弃以及仍在执行的任务数量
+		// 等待时间受上下文和 WithTimeout 限制, 超时后返回上下文错误
+		Shutdown(ctx context.Context, mode StopMode) (StopResult, error)
 	}
 )
 
@@ -41,8 +145,14 @@ func New(opts ...Option) Queue {
 		f(o)
 	}
 
+	var q Queue
 	if o.sharding == 1 {
-		return newSingleQueue(o)
+		q = newSingleQueue(o)
+	} else {
+		q = newMultipleQueue(o)
+	}
+	if o.wal != nil {
+		replay(q, o)
 	}
-	return newMultipleQueue(o)
+	return q
 }
@@ -2,12 +2,18 @@ package queues
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/lxzan/concurrency/logs"
+	"github.com/lxzan/concurrency/tracing"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -547,3 +553,2616 @@ func TestMultiQueue(t *testing.T) {
 		as.Equal(int64(1), expectedShard) // 12345 & 3 = 1
 	})
 }
+
+func TestPriorityQueue(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("order", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithPriority(0))
+		var list = make([]int, 0)
+		var mu sync.Mutex
+		var add = func(v int) Job {
+			return func() {
+				mu.Lock()
+				list = append(list, v)
+				mu.Unlock()
+			}
+		}
+		q.Push(func() { time.Sleep(20 * time.Millisecond) })
+		q.PushPriority(add(1), 1)
+		q.PushPriority(add(2), 5)
+		q.PushPriority(add(3), 1)
+		q.Push(add(4))
+		q.PushPriority(add(5), 9)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{5, 2, 1, 3, 4}, list)
+	})
+
+	t.Run("aging", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithPriority(10*time.Millisecond))
+		var list = make([]int, 0)
+		var mu sync.Mutex
+		var add = func(v int) Job {
+			return func() {
+				mu.Lock()
+				list = append(list, v)
+				mu.Unlock()
+			}
+		}
+		q.Push(func() { time.Sleep(100 * time.Millisecond) })
+		q.PushPriority(add(1), 0)
+		time.Sleep(50 * time.Millisecond)
+		q.PushPriority(add(2), 2)
+		q.PushPriority(add(3), 10)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{3, 1, 2}, list)
+	})
+
+	t.Run("aging overflow", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithPriority(time.Second))
+		var list = make([]int, 0)
+		q.Push(func() { time.Sleep(10 * time.Millisecond) })
+		q.PushPriority(func() { list = append(list, 1) }, 1)
+		q.PushPriority(func() { list = append(list, 2) }, math.MaxInt64)
+		q.PushPriority(func() { list = append(list, 3) }, math.MinInt64)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{2, 1, 3}, list)
+	})
+
+	t.Run("fifo ignore priority", func(t *testing.T) {
+		q := New(WithConcurrency(1))
+		var list = make([]int, 0)
+		q.Push(func() { time.Sleep(10 * time.Millisecond) })
+		q.PushPriority(func() { list = append(list, 1) }, 1)
+		q.PushPriority(func() { list = append(list, 2) }, 9)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{1, 2}, list)
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		q := New(WithSharding(4), WithConcurrency(1), WithPriority(time.Second))
+		var list = make([]int, 0)
+		var mu sync.Mutex
+		q.Push(func() { time.Sleep(20 * time.Millisecond) }, 1)
+		for i := 0; i < 5; i++ {
+			var v = i
+			q.PushPriority(func() {
+				mu.Lock()
+				list = append(list, v)
+				mu.Unlock()
+			}, v, 1)
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{4, 3, 2, 1, 0}, list)
+	})
+
+	t.Run("drop stealable", func(t *testing.T) {
+		var c = newPriorityContainer(0)
+		c.Push(&element{priority: 1, stealable: true})
+		c.Push(&element{priority: 2})
+		ele, ok := c.Drop()
+		as.True(ok)
+		as.Equal(1, ele.priority)
+		as.Equal(0, c.stealable)
+		_, ok = c.Steal()
+		as.False(ok)
+	})
+}
+
+func TestDelayQueue(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("push after", func(t *testing.T) {
+		q := New(WithConcurrency(2))
+		var list = make([]int, 0)
+		var mu sync.Mutex
+		var add = func(v int) Job {
+			return func() {
+				mu.Lock()
+				list = append(list, v)
+				mu.Unlock()
+			}
+		}
+		q.PushAfter(add(3), 60*time.Millisecond)
+		q.PushAfter(add(2), 40*time.Millisecond)
+		q.PushAt(add(1), time.Now().Add(20*time.Millisecond))
+		q.PushAfter(add(0), 0)
+		time.Sleep(5 * time.Millisecond)
+		as.Equal(3, q.Len())
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{0, 1, 2, 3}, list)
+		as.Equal(0, q.Len())
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		q := New(WithConcurrency(2))
+		var running, maxRunning = int32(0), int32(0)
+		var wg sync.WaitGroup
+		wg.Add(10)
+		for i := 0; i < 10; i++ {
+			q.PushAfter(func() {
+				defer wg.Done()
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			}, 10*time.Millisecond)
+		}
+		wg.Wait()
+		as.Equal(int32(2), atomic.LoadInt32(&maxRunning))
+	})
+
+	t.Run("discard on stop", func(t *testing.T) {
+		q := New(WithDiscardDelayed(), WithSharding(2))
+		var sum = int64(0)
+		q.PushAfter(func() { atomic.AddInt64(&sum, 1) }, time.Hour)
+		q.PushAfter(func() { atomic.AddInt64(&sum, 1) }, time.Hour)
+		as.Equal(2, q.Len())
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(0, q.Len())
+		as.Equal(int64(0), atomic.LoadInt64(&sum))
+	})
+
+	t.Run("stop timeout", func(t *testing.T) {
+		q := New(WithTimeout(50 * time.Millisecond))
+		q.PushAfter(func() {}, time.Hour)
+		as.Error(q.Stop(context.Background()))
+		as.Equal(1, q.Len())
+	})
+
+	t.Run("push after stop", func(t *testing.T) {
+		q := New()
+		as.NoError(q.Stop(context.Background()))
+		q.PushAfter(func() {}, time.Hour)
+		as.Equal(0, q.Len())
+	})
+}
+
+func TestCapacity(t *testing.T) {
+	as := assert.New(t)
+
+	// 占满并发槽位, 返回释放函数
+	var occupy = func(q Queue, hashcode ...int64) func() {
+		var ch = make(chan struct{})
+		q.Push(func() { <-ch }, hashcode...)
+		return func() { close(ch) }
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithCapacity(2), WithOverflowPolicy(OverflowReject))
+		release := occupy(q)
+		as.NoError(q.PushContext(context.Background(), func() {}))
+		as.True(q.TryPush(func() {}))
+		as.ErrorIs(q.PushContext(context.Background(), func() {}), ErrQueueFull)
+		as.False(q.TryPush(func() {}))
+		as.Equal(2, q.Len())
+		release()
+		as.NoError(q.Stop(context.Background()))
+		as.ErrorIs(q.PushContext(context.Background(), func() {}), ErrQueueStopped)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithCapacity(1))
+		release := occupy(q)
+		as.NoError(q.PushContext(context.Background(), func() {}))
+		as.False(q.TryPush(func() {}))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		as.ErrorIs(q.PushContext(ctx, func() {}), context.DeadlineExceeded)
+
+		var sum = int64(0)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			release()
+		}()
+		as.NoError(q.PushContext(context.Background(), func() { atomic.AddInt64(&sum, 1) }))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(1), atomic.LoadInt64(&sum))
+	})
+
+	t.Run("block until stop", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithCapacity(1), WithTimeout(10*time.Millisecond))
+		release := occupy(q)
+		defer release()
+		q.Push(func() {})
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			q.Stop(context.Background())
+		}()
+		as.ErrorIs(q.PushContext(context.Background(), func() {}), ErrQueueStopped)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithCapacity(2), WithOverflowPolicy(OverflowDropOldest))
+		release := occupy(q)
+		var list = make([]int, 0)
+		for i := 0; i < 4; i++ {
+			var v = i
+			as.True(q.TryPush(func() { list = append(list, v) }))
+		}
+		as.Equal(2, q.Len())
+		release()
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{2, 3}, list)
+	})
+
+	t.Run("drop lowest priority", func(t *testing.T) {
+		q := New(
+			WithConcurrency(1),
+			WithCapacity(3),
+			WithPriority(0),
+			WithOverflowPolicy(OverflowDropOldest),
+		)
+		release := occupy(q)
+		var list = make([]int, 0)
+		for _, v := range []int{3, 1, 4, 5, 2} {
+			var x = v
+			q.PushPriority(func() { list = append(list, x) }, x)
+		}
+		release()
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{5, 4, 2}, list)
+	})
+
+	t.Run("drop delayed only", func(t *testing.T) {
+		q := New(WithCapacity(1), WithOverflowPolicy(OverflowDropOldest), WithDiscardDelayed())
+		q.PushAfter(func() {}, time.Hour)
+		as.ErrorIs(q.PushContext(context.Background(), func() {}), ErrQueueFull)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("caller runs", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithCapacity(1), WithOverflowPolicy(OverflowCallerRuns))
+		release := occupy(q)
+		q.Push(func() {})
+		var ran = false
+		as.True(q.TryPush(func() { ran = true }))
+		as.True(ran)
+		release()
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("per shard", func(t *testing.T) {
+		q := New(
+			WithSharding(2),
+			WithConcurrency(1),
+			WithCapacity(1),
+			WithOverflowPolicy(OverflowReject),
+		)
+		release0 := occupy(q, 0)
+		release1 := occupy(q, 1)
+		as.True(q.TryPush(func() {}, 0))
+		as.False(q.TryPush(func() {}, 0))
+		as.True(q.TryPush(func() {}, 1))
+		as.ErrorIs(q.PushContext(context.Background(), func() {}, 1), ErrQueueFull)
+		release0()
+		release1()
+		as.NoError(q.Stop(context.Background()))
+	})
+}
+
+func TestContextJob(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("cancel on stop timeout", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithTimeout(20*time.Millisecond))
+		var exited = make(chan error, 1)
+		q.PushContextJob(func(ctx context.Context) {
+			<-ctx.Done()
+			exited <- ctx.Err()
+		})
+		as.Error(q.Stop(context.Background()))
+		select {
+		case err := <-exited:
+			as.ErrorIs(err, context.Canceled)
+		case <-time.After(time.Second):
+			as.Fail("context job was not cancelled")
+		}
+	})
+
+	t.Run("cancel on caller context", func(t *testing.T) {
+		q := New(WithSharding(2), WithConcurrency(1))
+		var exited = int64(0)
+		for i := 0; i < 2; i++ {
+			q.PushContextJob(func(ctx context.Context) {
+				<-ctx.Done()
+				atomic.AddInt64(&exited, 1)
+			}, int64(i))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		as.Error(q.Stop(ctx))
+		time.Sleep(10 * time.Millisecond)
+		as.Equal(int64(2), atomic.LoadInt64(&exited))
+	})
+
+	t.Run("graceful", func(t *testing.T) {
+		q := New()
+		var err = errors.New("")
+		q.PushContextJob(func(ctx context.Context) {
+			time.Sleep(10 * time.Millisecond)
+			err = ctx.Err()
+		})
+		as.NoError(q.Stop(context.Background()))
+		as.NoError(err)
+	})
+}
+
+func TestFuture(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("result", func(t *testing.T) {
+		q := New(WithSharding(4))
+		var futures = make([]*Future[int], 0)
+		for i := 1; i <= 10; i++ {
+			var x = i
+			futures = append(futures, Submit(context.Background(), q, func() (int, error) { return x * x, nil }))
+		}
+		var sum = 0
+		for _, f := range futures {
+			v, err := f.Get(context.Background())
+			as.NoError(err)
+			sum += v
+		}
+		as.Equal(385, sum)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		q := New()
+		f := Submit(context.Background(), q, func() (string, error) { return "", errors.New("test") })
+		<-f.Done()
+		_, err := f.Get(context.Background())
+		as.EqualError(err, "test")
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		q := New(WithRecovery())
+		f := Submit(context.Background(), q, func() (int, error) { panic("test") })
+		_, err := f.Get(context.Background())
+		as.ErrorIs(err, ErrJobPanic)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		q := New(WithConcurrency(1))
+		var ch = make(chan struct{})
+		q.Push(func() { <-ch })
+		var ran = false
+		f := Submit(context.Background(), q, func() (int, error) {
+			ran = true
+			return 1, nil
+		})
+		as.True(f.Cancel())
+		as.False(f.Cancel())
+		_, err := f.Get(context.Background())
+		as.ErrorIs(err, ErrFutureCanceled)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.False(ran)
+	})
+
+	t.Run("cancel after done", func(t *testing.T) {
+		q := New()
+		f := Submit(context.Background(), q, func() (int, error) { return 1, nil })
+		v, err := f.Get(context.Background())
+		as.NoError(err)
+		as.Equal(1, v)
+		as.False(f.Cancel())
+	})
+
+	t.Run("get timeout", func(t *testing.T) {
+		q := New()
+		f := Submit(context.Background(), q, func() (int, error) {
+			time.Sleep(50 * time.Millisecond)
+			return 1, nil
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := f.Get(ctx)
+		as.ErrorIs(err, context.DeadlineExceeded)
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithCapacity(1), WithOverflowPolicy(OverflowDropOldest))
+		q.Push(func() { <-ch })
+		f1 := Submit(context.Background(), q, func() (int, error) { return 1, nil })
+		f2 := Submit(context.Background(), q, func() (int, error) { return 2, nil })
+		_, err := f1.Get(context.Background())
+		as.ErrorIs(err, ErrQueueFull)
+
+		f3 := Submit(context.Background(), q, func() (int, error) { return 3, nil }, 1)
+		_, err = f2.Get(context.Background())
+		as.ErrorIs(err, ErrQueueFull)
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			close(ch)
+		}()
+		result, err := q.Shutdown(context.Background(), StopDiscard)
+		as.NoError(err)
+		as.Equal(1, result.Dropped)
+		_, err = f3.Get(context.Background())
+		as.ErrorIs(err, ErrQueueStopped)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1))
+		q.Push(func() { <-ch })
+		f := Submit(WithJobTTL(context.Background(), time.Millisecond), q, func() (int, error) { return 1, nil })
+		time.Sleep(5 * time.Millisecond)
+		close(ch)
+		_, err := f.Get(context.Background())
+		as.ErrorIs(err, ErrJobExpired)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("hand back", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1))
+		q.Push(func() { <-ch })
+		f := Submit(context.Background(), q, func() (int, error) { return 1, nil })
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			close(ch)
+		}()
+		result, err := q.Shutdown(context.Background(), StopHandBack)
+		as.NoError(err)
+		as.Len(result.Jobs, 1)
+		select {
+		case <-f.Done():
+		default:
+			as.Fail("future not done")
+		}
+		_, err = f.Get(context.Background())
+		as.ErrorIs(err, ErrQueueStopped)
+	})
+
+	t.Run("block timeout", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithCapacity(1))
+		q.Push(func() { <-ch })
+		q.Push(func() {})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		f := Submit(ctx, q, func() (int, error) { return 1, nil })
+		_, err := f.Get(context.Background())
+		as.ErrorIs(err, context.DeadlineExceeded)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		q := New()
+		as.NoError(q.Stop(context.Background()))
+		f := Submit(context.Background(), q, func() (int, error) { return 1, nil })
+		_, err := f.Get(context.Background())
+		as.ErrorIs(err, ErrQueueStopped)
+	})
+}
+
+func TestKeyedSerial(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("order", func(t *testing.T) {
+		q := New(WithConcurrency(8), WithSharding(2), WithKeyedSerial())
+		var mu sync.Mutex
+		var lists = make(map[int64][]int)
+		for i := 0; i < 100; i++ {
+			for key := int64(0); key < 4; key++ {
+				var k, v = key, i
+				q.Push(func() {
+					time.Sleep(time.Duration(v%3) * 100 * time.Microsecond)
+					mu.Lock()
+					lists[k] = append(lists[k], v)
+					mu.Unlock()
+				}, k)
+			}
+		}
+		as.NoError(q.Stop(context.Background()))
+		for key := int64(0); key < 4; key++ {
+			as.Len(lists[key], 100)
+			for i, v := range lists[key] {
+				as.Equal(i, v)
+			}
+		}
+	})
+
+	t.Run("parallel keys", func(t *testing.T) {
+		q := New(WithConcurrency(4), WithKeyedSerial())
+		var running, maxRunning = int32(0), int32(0)
+		var job = func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}
+		for i := 0; i < 3; i++ {
+			q.Push(job, 1)
+			q.Push(job, 2)
+		}
+		q.Push(job)
+		q.Push(job)
+		as.Equal(4, q.Len())
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int32(4), atomic.LoadInt32(&maxRunning))
+	})
+
+	t.Run("len and capacity", func(t *testing.T) {
+		q := New(
+			WithConcurrency(2),
+			WithKeyedSerial(),
+			WithCapacity(3),
+			WithOverflowPolicy(OverflowReject),
+		)
+		var ch = make(chan struct{})
+		as.True(q.TryPush(func() { <-ch }, 1))
+		as.True(q.TryPush(func() {}, 1))
+		as.True(q.TryPush(func() {}, 1))
+		as.True(q.TryPush(func() {}, 1))
+		as.False(q.TryPush(func() {}, 1))
+		as.Equal(3, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(0, q.Len())
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		q := New(
+			WithConcurrency(1),
+			WithKeyedSerial(),
+			WithCapacity(1),
+			WithOverflowPolicy(OverflowDropOldest),
+		)
+		var ch = make(chan struct{})
+		var list = make([]int, 0)
+		q.Push(func() { <-ch })
+		q.Push(func() { list = append(list, 1) }, 1)
+		q.Push(func() { list = append(list, 2) }, 2)
+		q.Push(func() { list = append(list, 3) }, 1)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{3}, list)
+	})
+
+	t.Run("caller runs", func(t *testing.T) {
+		q := New(
+			WithConcurrency(1),
+			WithKeyedSerial(),
+			WithCapacity(1),
+			WithOverflowPolicy(OverflowCallerRuns),
+		)
+		var ch = make(chan struct{})
+		q.Push(func() { <-ch }, 1)
+		q.Push(func() {}, 2)
+		as.False(q.TryPush(func() {}, 1))
+		as.True(q.TryPush(func() {}, 3))
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("delayed", func(t *testing.T) {
+		q := New(WithConcurrency(4), WithKeyedSerial())
+		var mu sync.Mutex
+		var list = make([]int, 0)
+		var add = func(v int) Job {
+			return func() {
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				list = append(list, v)
+				mu.Unlock()
+			}
+		}
+		q.Push(add(1), 1)
+		q.PushAfter(add(3), 2*time.Millisecond, 1)
+		q.Push(add(2), 1)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{1, 2, 3}, list)
+	})
+}
+
+func TestSetConcurrency(t *testing.T) {
+	as := assert.New(t)
+
+	// 统计最大并发
+	var newCounter = func() (job Job, max func() int32) {
+		var running, maxRunning = int32(0), int32(0)
+		job = func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}
+		return job, func() int32 { return atomic.LoadInt32(&maxRunning) }
+	}
+
+	t.Run("raise", func(t *testing.T) {
+		q := New(WithConcurrency(1))
+		as.Equal(uint32(1), q.Concurrency())
+		job, max := newCounter()
+		for i := 0; i < 8; i++ {
+			q.Push(job)
+		}
+		q.SetConcurrency(4)
+		as.Equal(uint32(4), q.Concurrency())
+		as.Equal(4, q.Len())
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int32(4), max())
+	})
+
+	t.Run("lower", func(t *testing.T) {
+		q := New(WithConcurrency(4))
+		var ch = make(chan struct{})
+		for i := 0; i < 4; i++ {
+			q.Push(func() { <-ch })
+		}
+		q.SetConcurrency(1)
+		job, max := newCounter()
+		for i := 0; i < 4; i++ {
+			q.Push(job)
+		}
+		as.Equal(4, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int32(1), max())
+	})
+
+	t.Run("ignore zero", func(t *testing.T) {
+		q := New(WithSharding(4), WithConcurrency(2))
+		q.SetConcurrency(0)
+		as.Equal(uint32(2), q.Concurrency())
+		q.SetConcurrency(3)
+		as.Equal(uint32(3), q.Concurrency())
+		as.NoError(q.Stop(context.Background()))
+	})
+}
+
+func TestWorkStealing(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("steal from busy shard", func(t *testing.T) {
+		q := New(WithSharding(2), WithConcurrency(1), WithWorkStealing())
+		var ch = make(chan struct{})
+		q.Push(func() { <-ch }, 0)
+		for i := 0; i < 4; i++ {
+			q.Push(func() {}, 0)
+		}
+
+		// 轮询分配的任务有一半会落到繁忙的分片0, 应该被分片1窃取执行
+		var wg sync.WaitGroup
+		wg.Add(8)
+		for i := 0; i < 8; i++ {
+			q.Push(func() { wg.Done() })
+		}
+		var done = make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			as.Fail("jobs were not stolen")
+		}
+		as.Equal(4, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("hashcode not stolen", func(t *testing.T) {
+		q := New(WithSharding(2), WithConcurrency(1), WithWorkStealing())
+		var ch = make(chan struct{})
+		var list = make([]int, 0)
+		q.Push(func() { <-ch }, 0)
+		for i := 0; i < 10; i++ {
+			var v = i
+			q.Push(func() { list = append(list, v) }, 0)
+		}
+		for i := 0; i < 10; i++ {
+			q.Push(func() {})
+		}
+		time.Sleep(20 * time.Millisecond)
+		as.Equal(10, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, list)
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		q := New(WithSharding(2), WithConcurrency(1), WithWorkStealing(), WithPriority(0))
+		var ch = make(chan struct{})
+		q.Push(func() { <-ch }, 0)
+		q.Push(func() { <-ch }, 1)
+		for i := 0; i < 8; i++ {
+			q.PushPriority(func() {}, i)
+		}
+		as.Equal(8, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("sum", func(t *testing.T) {
+		var val = int64(0)
+		q := New(WithConcurrency(2), WithSharding(8), WithWorkStealing())
+		for i := 1; i <= 1000; i++ {
+			args := int64(i)
+			q.Push(func() { atomic.AddInt64(&val, args) }, int64(i%3))
+			q.Push(func() { atomic.AddInt64(&val, args) })
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(1001000), val)
+	})
+}
+
+func TestStats(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("single queue", func(t *testing.T) {
+		q := New(
+			WithConcurrency(1),
+			WithRecovery(),
+			WithCapacity(2),
+			WithOverflowPolicy(OverflowReject),
+			WithLatencyStats(),
+		)
+		var ch = make(chan struct{})
+		q.Push(func() { <-ch })
+		q.Push(func() { panic("test") })
+		q.Push(func() { time.Sleep(2 * time.Millisecond) })
+		as.False(q.TryPush(func() {}))
+
+		var s = q.Stats()
+		as.Equal(2, s.Pending)
+		as.Equal(1, s.Running)
+		as.Equal(uint64(1), s.Rejected)
+		as.Len(s.Shards, 1)
+
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		s = q.Stats()
+		as.Equal(0, s.Pending)
+		as.Equal(0, s.Running)
+		as.Equal(uint64(3), s.Completed)
+		as.Equal(uint64(1), s.Panicked)
+		as.Equal(uint64(3), s.WaitLatency.Count)
+		as.Equal(uint64(3), s.ExecLatency.Count)
+		as.GreaterOrEqual(s.ExecLatency.Sum, 2*time.Millisecond)
+		as.Greater(s.ExecLatency.Mean(), time.Duration(0))
+		as.Len(s.ExecLatency.Counts, len(s.ExecLatency.Buckets)+1)
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		q := New(WithSharding(4), WithConcurrency(1), WithLatencyStats())
+		for i := 0; i < 100; i++ {
+			q.Push(func() {}, int64(i%2))
+		}
+		as.NoError(q.Stop(context.Background()))
+		var s = q.Stats()
+		as.Equal(uint64(100), s.Completed)
+		as.Equal(uint64(100), s.WaitLatency.Count)
+		as.Len(s.Shards, 4)
+		as.Equal(uint64(50), s.Shards[0].Completed)
+		as.Equal(uint64(50), s.Shards[1].Completed)
+		as.Equal(uint64(0), s.Shards[2].Completed)
+		as.Nil(s.Shards[0].Shards)
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithCapacity(1), WithOverflowPolicy(OverflowDropOldest))
+		var ch = make(chan struct{})
+		q.Push(func() { <-ch })
+		q.Push(func() {})
+		q.Push(func() {})
+		as.Equal(uint64(1), q.Stats().Dropped)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+}
+
+func TestTracing(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("span", func(t *testing.T) {
+		var recorder = tracing.NewRecorder()
+		q := New(WithConcurrency(1), WithRecovery(), WithTracer(recorder))
+		ctx, parent := recorder.Start(context.Background(), "parent", time.Now())
+
+		var ch = make(chan struct{})
+		var spanID = uint64(0)
+		q.Push(func() { <-ch })
+		as.NoError(q.PushWithContext(ctx, func(ctx context.Context) {
+			spanID = tracing.SpanID(ctx)
+			panic("test")
+		}))
+		as.NoError(q.PushContext(ctx, func() {}))
+		time.Sleep(5 * time.Millisecond)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		parent.End(time.Now())
+
+		var spans = recorder.Spans()
+		as.Len(spans, 4)
+		as.Equal(uint64(0), spans[0].ParentID)
+		as.Equal(spanID, spans[1].ID)
+		for i, span := range spans[:3] {
+			as.Equal("queues.job", span.Name)
+			as.Len(span.Events, 1)
+			as.Equal(tracing.EventJobStart, span.Events[0].Name)
+			as.False(span.End.Before(span.Events[0].Time))
+			panicked, _ := span.Attribute(tracing.AttrPanicked)
+			as.Equal(i == 1, panicked)
+			if i > 0 {
+				as.Equal(spans[3].ID, span.ParentID)
+				wait, _ := span.Attribute(tracing.AttrWait)
+				as.GreaterOrEqual(wait.(int64), int64(5*time.Millisecond))
+			}
+		}
+		as.Equal([]error{ErrJobPanic}, spans[1].Errors)
+		as.Empty(spans[2].Errors)
+	})
+
+	t.Run("context values", func(t *testing.T) {
+		type key struct{}
+		q := New()
+		var ch = make(chan any, 1)
+		var ctx = context.WithValue(context.Background(), key{}, "value")
+		as.NoError(q.PushWithContext(ctx, func(ctx context.Context) { ch <- ctx.Value(key{}) }))
+		as.Equal("value", <-ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+}
+
+func TestHooks(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("compose", func(t *testing.T) {
+		var started, finished, panicked = int64(0), int64(0), int64(0)
+		var mu = sync.Mutex{}
+		var values []any
+		var stack []byte
+		q := New(
+			WithConcurrency(2),
+			WithRecovery(),
+			WithOnJobStart(func(wait time.Duration) { atomic.AddInt64(&started, 1) }),
+			WithOnJobStart(func(wait time.Duration) { atomic.AddInt64(&started, 1) }),
+			WithOnJobFinish(func(elapsed time.Duration) {
+				as.GreaterOrEqual(elapsed, time.Duration(0))
+				atomic.AddInt64(&finished, 1)
+			}),
+			WithOnPanic(func(value any, s []byte) {
+				mu.Lock()
+				values, stack = append(values, value), s
+				mu.Unlock()
+			}),
+			WithOnPanic(func(value any, stack []byte) { atomic.AddInt64(&panicked, 1) }),
+		)
+		for i := 0; i < 10; i++ {
+			q.Push(func() {})
+		}
+		q.Push(func() { panic("test") })
+		as.NoError(q.Stop(context.Background()))
+
+		as.Equal(int64(22), atomic.LoadInt64(&started))
+		as.Equal(int64(11), atomic.LoadInt64(&finished))
+		as.Equal(int64(1), atomic.LoadInt64(&panicked))
+		as.Equal([]any{"test"}, values)
+		as.Contains(string(stack), "TestHooks")
+		as.Equal(uint64(1), q.Stats().Panicked)
+	})
+
+	t.Run("recovery still works", func(t *testing.T) {
+		var recovered any
+		q := New(WithOnPanic(func(value any, stack []byte) {}))
+		q.(*singleQueue).conf.caller = func(logger logs.Logger, f func()) {
+			defer func() { recovered = recover() }()
+			f()
+		}
+		q.Push(func() { panic("test") })
+		as.NoError(q.Stop(context.Background()))
+		as.Equal("test", recovered)
+	})
+}
+
+type testLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (c *testLogger) Errorf(format string, args ...any) {
+	c.mu.Lock()
+	c.logs = append(c.logs, fmt.Sprintf(format, args...))
+	c.mu.Unlock()
+}
+
+func TestRetry(t *testing.T) {
+	as := assert.New(t)
+	var errTest = errors.New("test")
+
+	t.Run("backoff", func(t *testing.T) {
+		var o = new(options)
+		WithRetry(RetryPolicy{MaxAttempts: 5, MaxBackoff: 300 * time.Millisecond})(o)
+		var policy = o.retry
+		as.Equal(defaultInitialBackoff, policy.InitialBackoff)
+		as.Equal(float64(defaultMultiplier), policy.Multiplier)
+
+		d, ok := policy.backoff(1, errTest)
+		as.True(ok)
+		as.Equal(100*time.Millisecond, d)
+		d, _ = policy.backoff(2, errTest)
+		as.Equal(200*time.Millisecond, d)
+		d, _ = policy.backoff(3, errTest)
+		as.Equal(300*time.Millisecond, d)
+		_, ok = policy.backoff(5, errTest)
+		as.False(ok)
+
+		policy.Jitter = 0.5
+		policy.Retryable = func(err error) bool { return err == errTest }
+		for i := 0; i < 100; i++ {
+			d, _ = policy.backoff(1, errTest)
+			as.GreaterOrEqual(d, 50*time.Millisecond)
+			as.LessOrEqual(d, 150*time.Millisecond)
+		}
+		for i := 0; i < 100; i++ {
+			d, _ = policy.backoff(4, errTest)
+			as.GreaterOrEqual(d, 150*time.Millisecond)
+			as.LessOrEqual(d, 300*time.Millisecond)
+		}
+		_, ok = policy.backoff(1, errors.New("other"))
+		as.False(ok)
+
+		var unbounded = RetryPolicy{MaxAttempts: math.MaxInt, InitialBackoff: time.Second, Multiplier: 2}
+		for _, attempt := range []int{40, 100, 2000} {
+			d, ok = unbounded.backoff(attempt, errTest)
+			as.True(ok)
+			as.Equal(maxBackoff, d)
+		}
+
+		var nilPolicy *RetryPolicy
+		_, ok = nilPolicy.backoff(1, errTest)
+		as.False(ok)
+	})
+
+	t.Run("succeed after retry", func(t *testing.T) {
+		q := New(WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+		var attempts = int64(0)
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error {
+			if atomic.AddInt64(&attempts, 1) < 3 {
+				return errTest
+			}
+			return nil
+		}))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(3), atomic.LoadInt64(&attempts))
+		var s = q.Stats()
+		as.Equal(uint64(3), s.Completed)
+		as.Equal(uint64(2), s.Retried)
+		as.Equal(uint64(0), s.Failed)
+	})
+
+	t.Run("give up", func(t *testing.T) {
+		var logger = &testLogger{}
+		q := New(
+			WithLogger(logger),
+			WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+		)
+		var attempts = int64(0)
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error {
+			atomic.AddInt64(&attempts, 1)
+			return errTest
+		}))
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error { return nil }))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(2), atomic.LoadInt64(&attempts))
+		var s = q.Stats()
+		as.Equal(uint64(1), s.Retried)
+		as.Equal(uint64(1), s.Failed)
+		as.Equal([]string{"queues: job failed after 2 attempts: test"}, logger.logs)
+	})
+
+	t.Run("slot released while waiting", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: 50 * time.Millisecond}))
+		var mu = sync.Mutex{}
+		var list []int
+		var appendList = func(v int) {
+			mu.Lock()
+			list = append(list, v)
+			mu.Unlock()
+		}
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error {
+			appendList(1)
+			return errTest
+		}))
+		time.Sleep(10 * time.Millisecond)
+		q.Push(func() { appendList(2) })
+		as.Equal(1, q.Len())
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{1, 2, 1}, list)
+	})
+
+	t.Run("keyed serial", func(t *testing.T) {
+		q := New(
+			WithConcurrency(4),
+			WithKeyedSerial(),
+			WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: 5 * time.Millisecond}),
+		)
+		var mu = sync.Mutex{}
+		var list []int
+		var attempts = 0
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			list = append(list, 1)
+			if attempts++; attempts < 3 {
+				return errTest
+			}
+			return nil
+		}, 1))
+		q.Push(func() {
+			mu.Lock()
+			list = append(list, 2)
+			mu.Unlock()
+		}, 1)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{1, 1, 1, 2}, list)
+	})
+
+	t.Run("discard delayed releases key", func(t *testing.T) {
+		q := New(
+			WithKeyedSerial(),
+			WithDiscardDelayed(),
+			WithTimeout(time.Second),
+			WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Hour}),
+		)
+		var ch = make(chan struct{})
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error { return errTest }, 1))
+		q.Push(func() { close(ch) }, 1)
+		time.Sleep(10 * time.Millisecond)
+		as.NoError(q.Stop(context.Background()))
+		<-ch
+	})
+}
+
+func TestDeadLetter(t *testing.T) {
+	as := assert.New(t)
+	var errTest = errors.New("test")
+
+	t.Run("panic and retry exhausted", func(t *testing.T) {
+		var sink = NewMemoryDeadLetters(0)
+		q := New(
+			WithConcurrency(1),
+			WithRecovery(),
+			WithLogger(&testLogger{}),
+			WithDeadLetter(sink),
+			WithRetry(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+		)
+		q.PushPriority(func() { panic("test") }, 5, 3)
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error { return errTest }))
+		q.Push(func() {})
+		as.NoError(q.Stop(context.Background()))
+
+		var letters = sink.Letters()
+		as.Len(letters, 2)
+		as.Equal(ErrJobPanic, letters[0].Err)
+		as.Equal("test", letters[0].Panic)
+		as.Contains(string(letters[0].Stack), "TestDeadLetter")
+		as.Equal([]int64{3}, letters[0].Hashcode)
+		as.Equal(5, letters[0].Priority)
+		as.Equal(1, letters[0].Attempts)
+		as.IsType(Job(nil), letters[0].Job)
+		as.Equal(errTest, letters[1].Err)
+		as.Nil(letters[1].Panic)
+		as.Nil(letters[1].Hashcode)
+		as.Equal(2, letters[1].Attempts)
+		as.IsType(ErrorJob(nil), letters[1].Job)
+		as.False(letters[1].FailedAt.IsZero())
+	})
+
+	t.Run("memory capacity", func(t *testing.T) {
+		var sink = NewMemoryDeadLetters(2)
+		for i := 1; i <= 3; i++ {
+			as.NoError(sink.Put(DeadLetter{Attempts: i}))
+		}
+		var letters = sink.Drain()
+		as.Len(letters, 2)
+		as.Equal(2, letters[0].Attempts)
+		as.Equal(0, sink.Len())
+	})
+
+	t.Run("redrive", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithPriority(0))
+		var err = Redrive(context.Background(), q,
+			DeadLetter{Job: Job(func() { atomic.AddInt64(&sum, 1) })},
+			DeadLetter{Job: Job(func() { atomic.AddInt64(&sum, 2) }), Priority: 1},
+			DeadLetter{Job: ContextJob(func(ctx context.Context) { atomic.AddInt64(&sum, 4) })},
+			DeadLetter{Job: ErrorJob(func(ctx context.Context) error { atomic.AddInt64(&sum, 8); return nil }), Hashcode: []int64{1}},
+			DeadLetter{},
+		)
+		as.ErrorIs(err, ErrNotRedrivable)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(15), atomic.LoadInt64(&sum))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		var path = t.TempDir() + "/dead_letters.jsonl"
+		sink, err := OpenFileDeadLetters(path)
+		as.NoError(err)
+		q := New(WithConcurrency(1), WithRecovery(), WithLogger(&testLogger{}), WithDeadLetter(sink))
+		q.Push(func() { panic("test") }, 7)
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error { return errTest }))
+		as.NoError(q.Stop(context.Background()))
+
+		letters, err := sink.Letters()
+		as.NoError(err)
+		as.Len(letters, 2)
+		as.NotNil(letters[0].Job)
+		as.NotNil(letters[1].Job)
+		as.NoError(sink.Close())
+
+		sink, err = OpenFileDeadLetters(path)
+		as.NoError(err)
+		as.NoError(sink.Put(DeadLetter{Job: Job(func() {}), Err: errTest, Attempts: 1}))
+		letters, err = sink.Drain()
+		as.NoError(err)
+		as.Len(letters, 3)
+		as.Nil(letters[0].Job)
+		as.Equal(ErrJobPanic.Error(), letters[0].Err.Error())
+		as.Equal("test", letters[0].Panic)
+		as.Equal([]int64{7}, letters[0].Hashcode)
+		as.NotEmpty(letters[0].Stack)
+		as.Nil(letters[1].Job)
+		as.Equal("test", letters[1].Err.Error())
+		as.NotNil(letters[2].Job)
+
+		letters, err = sink.Letters()
+		as.NoError(err)
+		as.Empty(letters)
+		as.NoError(sink.Close())
+	})
+
+	t.Run("file torn write", func(t *testing.T) {
+		var path = t.TempDir() + "/dead_letters.jsonl"
+		sink, err := OpenFileDeadLetters(path)
+		as.NoError(err)
+		as.NoError(sink.Put(DeadLetter{Err: errTest, Attempts: 1}))
+		as.NoError(sink.Close())
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		as.NoError(err)
+		_, err = file.WriteString(`{"name":"x","e`)
+		as.NoError(err)
+		as.NoError(file.Close())
+
+		sink, err = OpenFileDeadLetters(path)
+		as.NoError(err)
+		as.NoError(sink.Put(DeadLetter{Err: errTest, Attempts: 2}))
+		letters, err := sink.Drain()
+		as.NoError(err)
+		as.Len(letters, 2)
+		as.Equal(2, letters[1].Attempts)
+		as.NoError(sink.Close())
+	})
+}
+
+func TestWAL(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("replay", func(t *testing.T) {
+		var dir = t.TempDir()
+		var ch = make(chan struct{})
+		var sum = int64(0)
+		var handlers = map[string]Handler{
+			"block": func(ctx context.Context, payload []byte) error { <-ch; return nil },
+			"sum": func(ctx context.Context, payload []byte) error {
+				atomic.AddInt64(&sum, int64(payload[0]))
+				return nil
+			},
+		}
+		wal, err := OpenWAL(dir)
+		as.NoError(err)
+		q := New(WithConcurrency(1), WithLogger(&testLogger{}), WithWAL(wal, handlers))
+		as.NoError(q.PushDurable(context.Background(), "block", nil))
+		for i := 1; i <= 3; i++ {
+			as.NoError(q.PushDurable(context.Background(), "sum", []byte{byte(i)}, int64(i)))
+		}
+		as.Equal(4, wal.Len())
+		as.NoError(wal.Close()) // 模拟进程崩溃, 任务没有执行完成
+
+		wal, err = OpenWAL(dir)
+		as.NoError(err)
+		as.Equal(4, wal.Len())
+		handlers["block"] = func(ctx context.Context, payload []byte) error { return nil }
+		q = New(WithSharding(2), WithWAL(wal, handlers))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(6), atomic.LoadInt64(&sum))
+		as.Equal(0, wal.Len())
+		as.NoError(wal.Close())
+		close(ch)
+
+		wal, err = OpenWAL(dir)
+		as.NoError(err)
+		as.Equal(0, wal.Len())
+		as.NoError(wal.Close())
+	})
+
+	t.Run("limit key", func(t *testing.T) {
+		var dir = t.TempDir()
+		var ch = make(chan struct{})
+		var keys = make(chan string, 1)
+		var handlers = map[string]Handler{
+			"block": func(ctx context.Context, payload []byte) error { <-ch; return nil },
+			"key":   func(ctx context.Context, payload []byte) error { keys <- limitKey(ctx); return nil },
+		}
+		wal, err := OpenWAL(dir)
+		as.NoError(err)
+		q := New(WithConcurrency(1), WithKeyLimit(KeyLimit{Concurrency: 1}), WithWAL(wal, handlers))
+		as.NoError(q.PushDurable(context.Background(), "block", nil))
+		as.NoError(q.PushDurable(WithLimitKey(context.Background(), "k"), "key", nil))
+		as.NoError(wal.Close()) // 模拟进程崩溃, 任务没有执行完成
+
+		wal, err = OpenWAL(dir)
+		as.NoError(err)
+		handlers["block"] = func(ctx context.Context, payload []byte) error { return nil }
+		q = New(WithSharding(2), WithKeyLimit(KeyLimit{Concurrency: 1}), WithWAL(wal, handlers))
+		as.Equal("k", <-keys)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(0, wal.Len())
+		as.NoError(wal.Close())
+		close(ch)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var ch = make(chan struct{})
+		var expired = make(chan PendingJob, 1)
+		var handlers = map[string]Handler{
+			"block": func(ctx context.Context, payload []byte) error { <-ch; return nil },
+			"a":     func(ctx context.Context, payload []byte) error { return nil },
+		}
+		wal, err := OpenWAL(t.TempDir())
+		as.NoError(err)
+		q := New(WithConcurrency(1), WithWAL(wal, handlers), WithOnExpire(func(job PendingJob) { expired <- job }))
+		as.NoError(q.PushDurable(context.Background(), "block", nil))
+		as.NoError(q.PushDurable(WithJobTTL(context.Background(), time.Millisecond), "a", []byte("1")))
+		time.Sleep(10 * time.Millisecond)
+		close(ch)
+		var job = <-expired
+		as.Equal("a", job.Name)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(0, wal.Len())
+		as.NoError(wal.Close())
+	})
+
+	t.Run("unknown handler on replay", func(t *testing.T) {
+		var dir = t.TempDir()
+		wal, err := OpenWAL(dir)
+		as.NoError(err)
+		_, err = wal.add("old", nil, nil, "")
+		as.NoError(err)
+		var logger = &testLogger{}
+		q := New(WithLogger(logger), WithWAL(wal, nil))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(1, wal.Len())
+		as.Len(logger.logs, 1)
+		as.NoError(wal.Close())
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		as.ErrorIs(New().PushDurable(context.Background(), "a", nil), ErrNoWAL)
+
+		wal, err := OpenWAL(t.TempDir())
+		as.NoError(err)
+		q := New(WithWAL(wal, map[string]Handler{"a": func(ctx context.Context, payload []byte) error { return nil }}))
+		as.ErrorIs(q.PushDurable(context.Background(), "b", nil), ErrUnknownHandler)
+		as.NoError(q.Stop(context.Background()))
+		as.ErrorIs(q.PushDurable(context.Background(), "a", nil), ErrQueueStopped)
+		as.Equal(0, wal.Len())
+		as.NoError(wal.Close())
+		as.ErrorIs(q.PushDurable(context.Background(), "a", nil), ErrWALClosed)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		wal, err := OpenWAL(t.TempDir())
+		as.NoError(err)
+		var attempts = int64(0)
+		q := New(
+			WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+			WithWAL(wal, map[string]Handler{"a": func(ctx context.Context, payload []byte) error {
+				if atomic.AddInt64(&attempts, 1) < 3 {
+					as.Equal(1, wal.Len())
+					return errors.New("test")
+				}
+				return nil
+			}}),
+		)
+		as.NoError(q.PushDurable(context.Background(), "a", nil))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(3), atomic.LoadInt64(&attempts))
+		as.Equal(0, wal.Len())
+		as.NoError(wal.Close())
+	})
+
+	t.Run("compact", func(t *testing.T) {
+		var dir = t.TempDir()
+		wal, err := OpenWAL(dir)
+		as.NoError(err)
+		wal.threshold = 8
+		q := New(WithWAL(wal, map[string]Handler{"a": func(ctx context.Context, payload []byte) error { return nil }}))
+		for i := 0; i < 100; i++ {
+			as.NoError(q.PushDurable(context.Background(), "a", []byte("payload")))
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(0, wal.Len())
+		as.Less(wal.records, 8)
+
+		as.NoError(wal.Compact())
+		info, err := os.Stat(filepath.Join(dir, walFileName))
+		as.NoError(err)
+		as.Equal(int64(0), info.Size())
+		as.NoError(wal.Close())
+		as.ErrorIs(wal.Compact(), ErrWALClosed)
+	})
+
+	t.Run("torn write", func(t *testing.T) {
+		var dir = t.TempDir()
+		wal, err := OpenWAL(dir)
+		as.NoError(err)
+		_, err = wal.add("a", []byte("1"), nil, "")
+		as.NoError(err)
+		as.NoError(wal.Close())
+
+		file, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0644)
+		as.NoError(err)
+		_, err = file.WriteString(`{"op":"add","id":2,"na`)
+		as.NoError(err)
+		as.NoError(file.Close())
+
+		wal, err = OpenWAL(dir)
+		as.NoError(err)
+		as.Equal(1, wal.Len())
+		record, err := wal.add("a", nil, nil, "")
+		as.NoError(err)
+		as.Equal(uint64(2), record.ID)
+		as.NoError(wal.Close())
+
+		wal, err = OpenWAL(dir)
+		as.NoError(err)
+		as.Equal(2, wal.Len())
+		as.NoError(wal.Close())
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		var dir = t.TempDir()
+		wal, err := OpenWAL(dir)
+		as.NoError(err)
+		sink, err := OpenFileDeadLetters(filepath.Join(dir, "dead_letters.jsonl"))
+		as.NoError(err)
+		var fail = true
+		var ch = make(chan string, 1)
+		var handlers = map[string]Handler{"a": func(ctx context.Context, payload []byte) error {
+			if fail {
+				return errors.New("test")
+			}
+			ch <- string(payload)
+			return nil
+		}}
+		q := New(WithLogger(&testLogger{}), WithDeadLetter(sink), WithWAL(wal, handlers))
+		as.NoError(q.PushDurable(context.Background(), "a", []byte("hello"), 1))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(0, wal.Len())
+		as.NoError(sink.Close())
+
+		sink, err = OpenFileDeadLetters(filepath.Join(dir, "dead_letters.jsonl"))
+		as.NoError(err)
+		letters, err := sink.Drain()
+		as.NoError(err)
+		as.Len(letters, 1)
+		as.Nil(letters[0].Job)
+		as.Equal("a", letters[0].Name)
+		as.Equal([]int64{1}, letters[0].Hashcode)
+
+		fail = false
+		q = New(WithWAL(wal, handlers))
+		as.NoError(Redrive(context.Background(), q, letters...))
+		as.Equal("hello", <-ch)
+		as.NoError(q.Stop(context.Background()))
+		as.NoError(sink.Close())
+		as.NoError(wal.Close())
+	})
+}
+
+func TestPause(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("single queue", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(2))
+		q.Push(func() { <-ch; atomic.AddInt64(&sum, 1) })
+		q.Pause()
+		for i := 0; i < 5; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		q.PushAfter(func() { atomic.AddInt64(&sum, 1) }, time.Millisecond)
+		close(ch)
+		time.Sleep(20 * time.Millisecond)
+		as.Equal(int64(1), atomic.LoadInt64(&sum))
+
+		var s = q.Stats()
+		as.True(s.Paused)
+		as.Equal(6, s.Pending)
+		as.Equal(0, s.Running)
+
+		q.Resume()
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(7), atomic.LoadInt64(&sum))
+		as.False(q.Stats().Paused)
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithSharding(4), WithWorkStealing(), WithConcurrency(2))
+		q.Pause()
+		for i := 0; i < 20; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		q.SetConcurrency(4)
+		time.Sleep(10 * time.Millisecond)
+		as.Equal(int64(0), atomic.LoadInt64(&sum))
+		var s = q.Stats()
+		as.True(s.Paused)
+		for _, shard := range s.Shards {
+			as.True(shard.Paused)
+		}
+
+		q.Resume()
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(20), atomic.LoadInt64(&sum))
+	})
+
+	t.Run("caller runs", func(t *testing.T) {
+		q := New(WithCapacity(1), WithOverflowPolicy(OverflowCallerRuns))
+		q.Pause()
+		as.NoError(q.PushContext(context.Background(), func() {}))
+		as.ErrorIs(q.PushContext(context.Background(), func() {}), ErrQueueFull)
+		q.Resume()
+		as.NoError(q.Stop(context.Background()))
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("drain", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithConcurrency(2))
+		for i := 0; i < 10; i++ {
+			q.Push(func() { time.Sleep(time.Millisecond); atomic.AddInt64(&sum, 1) })
+		}
+		result, err := q.Shutdown(context.Background(), StopDrain)
+		as.NoError(err)
+		as.Equal(int64(10), atomic.LoadInt64(&sum))
+		as.Equal(0, result.Dropped)
+		as.Equal(0, result.Running)
+		as.LessOrEqual(result.Completed, 10)
+		as.Empty(result.Jobs)
+
+		result, err = q.Shutdown(context.Background(), StopDiscard)
+		as.NoError(err)
+		as.Equal(StopResult{}, result)
+	})
+
+	t.Run("discard", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithKeyedSerial())
+		q.Push(func() { <-ch; atomic.AddInt64(&sum, 1) }, 1)
+		for i := 0; i < 3; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) }, 1)
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		q.PushAfter(func() { atomic.AddInt64(&sum, 1) }, time.Hour)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(ch)
+		}()
+		result, err := q.Shutdown(context.Background(), StopDiscard)
+		as.NoError(err)
+		as.Equal(7, result.Dropped)
+		as.Equal(1, result.Completed)
+		as.Equal(0, result.Running)
+		as.Equal(int64(1), atomic.LoadInt64(&sum))
+		as.Equal(0, q.Len())
+		as.Equal(uint64(7), q.Stats().Dropped)
+	})
+
+	t.Run("drain discard delayed", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithConcurrency(1), WithSharding(1), WithDiscardDelayed())
+		for i := 0; i < 5; i++ {
+			q.PushAfter(func() { atomic.AddInt64(&sum, 1) }, time.Hour)
+		}
+		q.Push(func() { atomic.AddInt64(&sum, 10) })
+		result, err := q.Shutdown(context.Background(), StopDrain)
+		as.NoError(err)
+		as.Equal(5, result.Dropped)
+		as.Equal(int64(10), atomic.LoadInt64(&sum))
+		as.Equal(uint64(5), q.Stats().Dropped)
+	})
+
+	t.Run("hand back", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(
+			WithConcurrency(1),
+			WithKeyedSerial(),
+			WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}),
+		)
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error {
+			<-ch
+			return errors.New("test")
+		}, 2))
+		q.Push(func() { atomic.AddInt64(&sum, 1) }, 2)
+		q.PushPriority(func() { atomic.AddInt64(&sum, 1) }, 3)
+		var at = time.Now().Add(time.Hour)
+		q.PushAt(func() { atomic.AddInt64(&sum, 1) }, at)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(ch)
+		}()
+		result, err := q.Shutdown(context.Background(), StopHandBack)
+		as.NoError(err)
+		as.Equal(0, result.Dropped)
+		as.Equal(1, result.Completed)
+		as.Equal(int64(0), atomic.LoadInt64(&sum))
+		as.Equal(4, len(result.Jobs))
+
+		var retried, delayed int
+		for _, job := range result.Jobs {
+			switch {
+			case job.Attempts == 1:
+				retried++
+				as.Equal([]int64{2}, job.Hashcode)
+				as.IsType(ErrorJob(nil), job.Job)
+				as.True(job.At.After(time.Now()))
+			case !job.At.IsZero():
+				delayed++
+				as.Equal(at.UnixNano(), job.At.UnixNano())
+			}
+		}
+		as.Equal(1, retried)
+		as.Equal(1, delayed)
+
+		var q1 = New()
+		as.NoError(Requeue(context.Background(), q1, result.Jobs[:2]...))
+		as.NoError(q1.Stop(context.Background()))
+	})
+
+	t.Run("discard cancels context", func(t *testing.T) {
+		q := New(WithTimeout(time.Second))
+		var err error
+		q.PushWithContext(context.Background(), func(ctx context.Context) {
+			<-ctx.Done()
+			err = ctx.Err()
+		})
+		time.Sleep(5 * time.Millisecond)
+		var t0 = time.Now()
+		result, stopErr := q.Shutdown(context.Background(), StopDiscard)
+		as.NoError(stopErr)
+		as.Less(time.Since(t0), 500*time.Millisecond)
+		as.Equal(0, result.Running)
+		as.Equal(1, result.Completed)
+		as.ErrorIs(err, context.Canceled)
+	})
+
+	t.Run("requeue priority", func(t *testing.T) {
+		var ch = make(chan struct{})
+		var list []int
+		var add = func(v int) Job { return func() { list = append(list, v) } }
+		var jobs = []PendingJob{
+			{Job: add(1), Priority: 1},
+			{Job: add(3), Priority: 3},
+			{Job: ErrorJob(func(ctx context.Context) error { list = append(list, 2); return nil }), Priority: 2},
+		}
+		q := New(WithConcurrency(1), WithSharding(1), WithPriority(0))
+		q.Push(func() { <-ch })
+		as.NoError(Requeue(context.Background(), q, jobs...))
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{3, 2, 1}, list)
+
+		var err = Requeue(context.Background(), q, jobs[0])
+		as.ErrorIs(err, ErrQueueStopped)
+
+		var ch1 = make(chan struct{})
+		var q1 = New(WithConcurrency(1), WithPriority(0), WithCapacity(1), WithOverflowPolicy(OverflowBlock))
+		q1.Push(func() { <-ch1 })
+		q1.Push(func() {})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		as.ErrorIs(Requeue(ctx, q1, jobs[0]), context.DeadlineExceeded)
+		close(ch1)
+		as.NoError(q1.Stop(context.Background()))
+	})
+
+	t.Run("hand back durable", func(t *testing.T) {
+		var dir = t.TempDir()
+		wal, err := OpenWAL(dir)
+		as.NoError(err)
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		var handlers = map[string]Handler{
+			"add": func(ctx context.Context, payload []byte) error {
+				atomic.AddInt64(&sum, 1)
+				return nil
+			},
+		}
+		q := New(WithConcurrency(1), WithWAL(wal, handlers))
+		q.Push(func() { <-ch })
+		as.NoError(q.PushDurable(context.Background(), "add", nil))
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			close(ch)
+		}()
+		result, err := q.Shutdown(context.Background(), StopHandBack)
+		as.NoError(err)
+		as.Len(result.Jobs, 1)
+		as.Equal(0, wal.Len())
+
+		var q1 = New(WithWAL(wal, handlers))
+		as.NoError(Requeue(context.Background(), q1, result.Jobs...))
+		as.NoError(q1.Stop(context.Background()))
+		as.Equal(int64(1), atomic.LoadInt64(&sum))
+		as.Equal(0, wal.Len())
+		as.NoError(wal.Close())
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithSharding(4), WithConcurrency(1))
+		for i := 0; i < 4; i++ {
+			q.Push(func() { <-ch }, int64(i))
+		}
+		for i := 0; i < 8; i++ {
+			q.Push(func() {}, int64(i))
+		}
+		time.Sleep(10 * time.Millisecond)
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(ch)
+		}()
+		result, err := q.Shutdown(context.Background(), StopHandBack)
+		as.NoError(err)
+		as.Equal(4, result.Completed)
+		as.Equal(8, len(result.Jobs))
+		for i, job := range result.Jobs {
+			as.Equal(int64(i/2)&3, job.Hashcode[0]&3)
+		}
+	})
+
+	t.Run("wake up", func(t *testing.T) {
+		for _, q := range []Queue{New(), New(WithSharding(4), WithWorkStealing())} {
+			for i := 0; i < 8; i++ {
+				q.Push(func() { time.Sleep(5 * time.Millisecond) })
+			}
+			var t0 = time.Now()
+			as.NoError(q.Stop(context.Background()))
+			as.Less(time.Since(t0), 50*time.Millisecond)
+			as.Equal(0, q.Stats().Running)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var ch = make(chan struct{})
+		defer close(ch)
+		q := New(WithSharding(2), WithConcurrency(1), WithTimeout(20*time.Millisecond))
+		q.Push(func() { <-ch }, 0)
+		q.Push(func() {}, 0)
+		q.Push(func() {}, 1)
+		time.Sleep(10 * time.Millisecond)
+		result, err := q.Shutdown(context.Background(), StopDiscard)
+		as.ErrorIs(err, context.DeadlineExceeded)
+		as.Equal(1, result.Dropped)
+		as.Equal(1, result.Running)
+		as.Equal(0, result.Completed)
+	})
+}
+
+func TestWorkerPool(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("reuse", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithConcurrency(2), WithWorkerPool(0))
+		for i := 0; i < 10; i++ {
+			var wg = &sync.WaitGroup{}
+			wg.Add(4)
+			for j := 0; j < 4; j++ {
+				q.Push(func() { atomic.AddInt64(&sum, 1); wg.Done() })
+			}
+			wg.Wait()
+			time.Sleep(time.Millisecond)
+			var s = q.Stats()
+			as.Equal(0, s.Running)
+			as.LessOrEqual(s.Idle, 2)
+			as.Greater(s.Idle, 0)
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(40), atomic.LoadInt64(&sum))
+		as.Eventually(func() bool { return q.Stats().Idle == 0 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		q := New(WithWorkerPool(20 * time.Millisecond))
+		var wg = &sync.WaitGroup{}
+		wg.Add(1)
+		q.Push(func() { wg.Done() })
+		wg.Wait()
+		as.Eventually(func() bool { return q.Stats().Idle == 1 }, time.Second, time.Millisecond)
+		as.Eventually(func() bool { return q.Stats().Idle == 0 }, time.Second, time.Millisecond)
+
+		wg.Add(1)
+		q.Push(func() { wg.Done() })
+		wg.Wait()
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithSharding(4), WithConcurrency(2), WithWorkStealing(), WithWorkerPool(time.Second))
+		for i := 0; i < 1000; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+			if i%100 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(1000), atomic.LoadInt64(&sum))
+		as.LessOrEqual(q.Stats().Idle, 8)
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		var running, maxRunning int32
+		q := New(WithConcurrency(3), WithWorkerPool(0))
+		for i := 0; i < 5; i++ {
+			for j := 0; j < 10; j++ {
+				q.Push(func() {
+					var n = atomic.AddInt32(&running, 1)
+					for {
+						var m = atomic.LoadInt32(&maxRunning)
+						if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond)
+					atomic.AddInt32(&running, -1)
+				})
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int32(3), atomic.LoadInt32(&maxRunning))
+	})
+}
+
+// 手动推进的时钟, 推进时同步触发到期的定时器
+type manualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock   *manualClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var t = &manualTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*manualTimer
+	var rest = c.timers[:0]
+	for _, t := range c.timers {
+		if t.stopped {
+			continue
+		}
+		if !t.at.After(c.now) {
+			due = append(due, t)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	c.timers = rest
+	c.mu.Unlock()
+
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func (c *manualTimer) Stop() bool {
+	c.clock.mu.Lock()
+	defer c.clock.mu.Unlock()
+	var active = !c.stopped
+	c.stopped = true
+	return active
+}
+
+func TestRateLimit(t *testing.T) {
+	as := assert.New(t)
+
+	var count = func(sum *int64, n int64) bool {
+		return assert.Eventually(t, func() bool { return atomic.LoadInt64(sum) == n }, time.Second, time.Millisecond) &&
+			assert.Never(t, func() bool { return atomic.LoadInt64(sum) > n }, 20*time.Millisecond, time.Millisecond)
+	}
+
+	t.Run("single queue", func(t *testing.T) {
+		var sum = int64(0)
+		var clock = newManualClock()
+		q := New(WithConcurrency(8), WithRateLimit(10, 2), WithClock(clock))
+		for i := 0; i < 5; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		count(&sum, 2)
+		as.Equal(3, q.Len())
+
+		clock.Advance(100 * time.Millisecond)
+		count(&sum, 3)
+
+		clock.Advance(time.Second)
+		count(&sum, 5)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("global", func(t *testing.T) {
+		var sum = int64(0)
+		var clock = newManualClock()
+		q := New(WithSharding(4), WithRateLimit(1, 2), WithClock(clock))
+		for i := 0; i < 8; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		count(&sum, 2)
+		clock.Advance(3 * time.Second)
+		count(&sum, 4)
+		clock.Advance(time.Hour)
+		count(&sum, 6)
+		clock.Advance(time.Hour)
+		count(&sum, 8)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("per shard", func(t *testing.T) {
+		var sum = int64(0)
+		var clock = newManualClock()
+		q := New(WithSharding(4), WithShardRateLimit(1, 1), WithClock(clock))
+		for i := 0; i < 8; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		count(&sum, 4)
+		clock.Advance(time.Second)
+		count(&sum, 8)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("caller runs", func(t *testing.T) {
+		var clock = newManualClock()
+		q := New(WithCapacity(1), WithOverflowPolicy(OverflowCallerRuns), WithRateLimit(1, 1), WithClock(clock))
+		as.NoError(q.PushContext(context.Background(), func() {}))
+		as.NoError(q.PushContext(context.Background(), func() {}))
+		as.ErrorIs(q.PushContext(context.Background(), func() {}), ErrQueueFull)
+		clock.Advance(time.Second)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("system clock", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithRateLimit(100, 1))
+		var t0 = time.Now()
+		for i := 0; i < 5; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(5), atomic.LoadInt64(&sum))
+		as.GreaterOrEqual(time.Since(t0), 35*time.Millisecond)
+	})
+}
+
+func TestKeyLimit(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("concurrency", func(t *testing.T) {
+		var running = map[string]*int32{"a": new(int32), "b": new(int32)}
+		var maxRunning = map[string]*int32{"a": new(int32), "b": new(int32)}
+		var sum = int64(0)
+		q := New(WithConcurrency(8), WithKeyLimit(KeyLimit{Concurrency: 2}))
+		for i := 0; i < 20; i++ {
+			var key = keyOf(i)
+			var ctx = WithLimitKey(context.Background(), key)
+			as.NoError(q.PushContext(ctx, func() {
+				var n = atomic.AddInt32(running[key], 1)
+				for {
+					var m = atomic.LoadInt32(maxRunning[key])
+					if n <= m || atomic.CompareAndSwapInt32(maxRunning[key], m, n) {
+						break
+					}
+				}
+				time.Sleep(2 * time.Millisecond)
+				atomic.AddInt32(running[key], -1)
+				atomic.AddInt64(&sum, 1)
+			}))
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(20), atomic.LoadInt64(&sum))
+		as.Equal(int32(2), atomic.LoadInt32(maxRunning["a"]))
+		as.Equal(int32(2), atomic.LoadInt32(maxRunning["b"]))
+		as.Equal(0, q.Stats().Keys)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		var mu sync.Mutex
+		var list []int
+		q := New(WithSharding(4), WithKeyLimit(KeyLimit{Concurrency: 1}))
+		var ctx = WithLimitKey(context.Background(), "tenant")
+		for i := 0; i < 100; i++ {
+			var n = i
+			as.NoError(q.PushContext(ctx, func() {
+				mu.Lock()
+				list = append(list, n)
+				mu.Unlock()
+			}))
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(100, len(list))
+		for i, v := range list {
+			as.Equal(i, v)
+		}
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		var sums = map[string]*int64{"a": new(int64), "b": new(int64)}
+		var clock = newManualClock()
+		q := New(WithClock(clock), WithKeyLimit(KeyLimit{Rate: 1, Burst: 2}))
+		for i := 0; i < 10; i++ {
+			var key = keyOf(i)
+			as.NoError(q.PushContext(WithLimitKey(context.Background(), key), func() { atomic.AddInt64(sums[key], 1) }))
+		}
+		as.NoError(q.PushContext(context.Background(), func() {}))
+		as.Eventually(func() bool {
+			return atomic.LoadInt64(sums["a"]) == 2 && atomic.LoadInt64(sums["b"]) == 2
+		}, time.Second, time.Millisecond)
+		as.Equal(6, q.Len())
+
+		clock.Advance(time.Second)
+		as.Eventually(func() bool {
+			return atomic.LoadInt64(sums["a"]) == 3 && atomic.LoadInt64(sums["b"]) == 3
+		}, time.Second, time.Millisecond)
+		as.Equal(2, q.Stats().Keys)
+
+		clock.Advance(time.Hour)
+		as.Eventually(func() bool { return q.Len() == 0 }, time.Second, time.Millisecond)
+		clock.Advance(time.Hour)
+		as.Eventually(func() bool { return q.Len() == 0 }, time.Second, time.Millisecond)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(5), atomic.LoadInt64(sums["a"]))
+		as.Equal(int64(5), atomic.LoadInt64(sums["b"]))
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		var clock = newManualClock()
+		q := New(WithClock(clock), WithKeyLimit(KeyLimit{Concurrency: 1, Rate: 10, Burst: 5}))
+		for i := 0; i < 1000; i++ {
+			as.NoError(q.PushContext(WithLimitKey(context.Background(), fmt.Sprintf("tenant-%d", i)), func() {}))
+		}
+		as.Eventually(func() bool { return q.Len() == 0 && q.Stats().Running == 0 }, time.Second, time.Millisecond)
+		as.Equal(1000, q.Stats().Keys)
+
+		clock.Advance(400 * time.Millisecond)
+		as.Equal(1000, q.Stats().Keys)
+		clock.Advance(100 * time.Millisecond)
+		as.Equal(0, q.Stats().Keys)
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("hand back", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithKeyLimit(KeyLimit{Concurrency: 1}))
+		var ctx = WithLimitKey(context.Background(), "a")
+		as.NoError(q.PushContext(ctx, func() { <-ch }))
+		as.NoError(q.PushContext(ctx, func() {}))
+		as.NoError(q.PushContext(ctx, func() {}))
+		as.Equal(2, q.Len())
+		close(ch)
+		result, err := q.Shutdown(context.Background(), StopHandBack)
+		as.NoError(err)
+		as.Equal(2, len(result.Jobs))
+		as.Equal("a", result.Jobs[0].LimitKey)
+	})
+
+	t.Run("rate at dispatch", func(t *testing.T) {
+		var ch = make(chan struct{})
+		var mu sync.Mutex
+		var started []time.Time
+		q := New(WithConcurrency(1), WithKeyLimit(KeyLimit{Rate: 10, Burst: 1}))
+		var ctx = WithLimitKey(context.Background(), "a")
+		as.NoError(q.PushContext(ctx, func() { <-ch }))
+		for i := 0; i < 4; i++ {
+			as.NoError(q.PushContext(ctx, func() {
+				mu.Lock()
+				started = append(started, time.Now())
+				mu.Unlock()
+			}))
+		}
+		time.Sleep(300 * time.Millisecond)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(4, len(started))
+		for i := 1; i < len(started); i++ {
+			as.GreaterOrEqual(started[i].Sub(started[i-1]), 80*time.Millisecond)
+		}
+	})
+
+	t.Run("push during stop", func(t *testing.T) {
+		q := New(WithConcurrency(2), WithKeyLimit(KeyLimit{Concurrency: 1, Rate: 1000, Burst: 1}))
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					_ = q.PushContext(WithLimitKey(context.Background(), keyOf(i+j)), func() {})
+				}
+			}(i)
+		}
+		_, err := q.Shutdown(context.Background(), StopDiscard)
+		as.NoError(err)
+		wg.Wait()
+	})
+
+	t.Run("caller runs", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(
+			WithCapacity(1),
+			WithOverflowPolicy(OverflowCallerRuns),
+			WithKeyLimit(KeyLimit{Concurrency: 1}),
+		)
+		var ctx = WithLimitKey(context.Background(), "a")
+		as.NoError(q.PushContext(ctx, func() { <-ch }))
+		as.NoError(q.PushContext(ctx, func() {}))
+		as.ErrorIs(q.PushContext(ctx, func() {}), ErrQueueFull)
+		as.NoError(q.PushContext(WithLimitKey(context.Background(), "b"), func() {}))
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+}
+
+// 按奇偶选择限制键
+func keyOf(i int) string {
+	if i%2 == 0 {
+		return "a"
+	}
+	return "b"
+}
+
+func TestPushUnique(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("drop", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1))
+		q.Push(func() { <-ch })
+		as.NoError(q.PushUnique(context.Background(), "a", func() { atomic.AddInt64(&sum, 1) }))
+		as.ErrorIs(q.PushUnique(context.Background(), "a", func() { atomic.AddInt64(&sum, 10) }), ErrDuplicateJob)
+		as.NoError(q.PushUnique(context.Background(), "b", func() { atomic.AddInt64(&sum, 100) }))
+		as.Equal(2, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(101), atomic.LoadInt64(&sum))
+		as.Equal(uint64(1), q.Stats().Deduplicated)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		var list []int
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithUniquePolicy(UniqueReplace))
+		q.Push(func() { <-ch })
+		for i := 0; i < 5; i++ {
+			var n = i
+			as.NoError(q.PushUnique(context.Background(), "a", func() { list = append(list, n) }))
+		}
+		q.Push(func() { list = append(list, 100) })
+		as.Equal(2, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{4, 100}, list)
+		as.Equal(uint64(4), q.Stats().Deduplicated)
+	})
+
+	t.Run("release on start", func(t *testing.T) {
+		var sum = int64(0)
+		var started = make(chan struct{})
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1))
+		as.NoError(q.PushUnique(context.Background(), "a", func() {
+			close(started)
+			<-ch
+			atomic.AddInt64(&sum, 1)
+		}))
+		<-started
+		as.NoError(q.PushUnique(context.Background(), "a", func() { atomic.AddInt64(&sum, 1) }))
+		as.ErrorIs(q.PushUnique(context.Background(), "a", func() {}), ErrDuplicateJob)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(2), atomic.LoadInt64(&sum))
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(WithSharding(8), WithConcurrency(1), WithWorkStealing())
+		for i := 0; i < 8; i++ {
+			q.Push(func() { <-ch }, int64(i))
+		}
+		for i := 0; i < 100; i++ {
+			_ = q.PushUnique(context.Background(), fmt.Sprintf("key-%d", i%10), func() { atomic.AddInt64(&sum, 1) })
+		}
+		as.Equal(10, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(10), atomic.LoadInt64(&sum))
+		as.Equal(uint64(90), q.Stats().Deduplicated)
+	})
+
+	t.Run("limit key routing", func(t *testing.T) {
+		var running, maxRunning int32
+		q := New(WithSharding(8), WithKeyLimit(KeyLimit{Concurrency: 1}))
+		var ctx = WithLimitKey(context.Background(), "tenant")
+		for i := 0; i < 16; i++ {
+			as.NoError(q.PushUnique(ctx, fmt.Sprintf("key-%d", i), func() {
+				if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&maxRunning) {
+					atomic.StoreInt32(&maxRunning, n)
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+			}))
+		}
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int32(1), atomic.LoadInt32(&maxRunning))
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithCapacity(1), WithOverflowPolicy(OverflowDropOldest))
+		q.Push(func() { <-ch })
+		as.NoError(q.PushUnique(context.Background(), "a", func() {}))
+		q.Push(func() {})
+		as.NoError(q.PushUnique(context.Background(), "a", func() {}))
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(uint64(2), q.Stats().Dropped)
+	})
+
+	t.Run("hand back", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithUniquePolicy(UniqueReplace))
+		q.Push(func() { <-ch })
+		as.NoError(q.PushUnique(context.Background(), "a", func() { atomic.AddInt64(&sum, 1) }))
+		as.NoError(q.PushUnique(context.Background(), "a", func() { atomic.AddInt64(&sum, 2) }))
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(ch)
+		}()
+		result, err := q.Shutdown(context.Background(), StopHandBack)
+		as.NoError(err)
+		as.Equal(1, len(result.Jobs))
+		as.Equal("a", result.Jobs[0].Unique)
+
+		var q1 = New()
+		as.NoError(Requeue(context.Background(), q1, result.Jobs...))
+		as.NoError(q1.Stop(context.Background()))
+		as.Equal(int64(2), atomic.LoadInt64(&sum))
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("retry", func(t *testing.T) {
+		var attempts int64
+		q := New(
+			WithConcurrency(1),
+			WithLogger(&testLogger{}),
+			WithDefaultTTL(50*time.Millisecond),
+			WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond}),
+		)
+		as.NoError(q.PushErrorJob(context.Background(), func(ctx context.Context) error {
+			atomic.AddInt64(&attempts, 1)
+			return errors.New("test")
+		}))
+		as.NoError(q.Stop(context.Background()))
+		var s = q.Stats()
+		as.Equal(int64(3), atomic.LoadInt64(&attempts))
+		as.Equal(uint64(0), s.Expired)
+		as.Equal(uint64(2), s.Retried)
+		as.Equal(uint64(1), s.Failed)
+	})
+
+	t.Run("default ttl", func(t *testing.T) {
+		var sum = int64(0)
+		var expired = make(chan PendingJob, 8)
+		var ch = make(chan struct{})
+		q := New(
+			WithConcurrency(1),
+			WithDefaultTTL(10*time.Millisecond),
+			WithOnExpire(func(job PendingJob) { expired <- job }),
+		)
+		q.Push(func() { <-ch })
+		for i := 0; i < 3; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		time.Sleep(20 * time.Millisecond)
+		q.Push(func() { atomic.AddInt64(&sum, 10) })
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(10), atomic.LoadInt64(&sum))
+		as.Equal(uint64(3), q.Stats().Expired)
+		as.Equal(uint64(2), q.Stats().Completed)
+		for i := 0; i < 3; i++ {
+			select {
+			case job := <-expired:
+				as.IsType(Job(nil), job.Job)
+			case <-time.After(time.Second):
+				as.Fail("expire hook not called")
+			}
+		}
+	})
+
+	t.Run("per push", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithDefaultTTL(time.Hour))
+		q.Push(func() { <-ch })
+		var ctx = WithJobTTL(context.Background(), time.Millisecond)
+		as.NoError(q.PushContext(ctx, func() { atomic.AddInt64(&sum, 1) }))
+		ctx = WithJobDeadline(context.Background(), time.Now().Add(time.Millisecond))
+		as.NoError(q.PushWithContext(ctx, func(ctx context.Context) { atomic.AddInt64(&sum, 1) }))
+		as.NoError(q.PushContext(context.Background(), func() { atomic.AddInt64(&sum, 10) }))
+		time.Sleep(10 * time.Millisecond)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(10), atomic.LoadInt64(&sum))
+		as.Equal(uint64(2), q.Stats().Expired)
+	})
+
+	t.Run("already expired", func(t *testing.T) {
+		q := New()
+		var ctx = WithJobDeadline(context.Background(), time.Now().Add(-time.Second))
+		as.NoError(q.PushContext(ctx, func() { as.Fail("expired job executed") }))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(uint64(1), q.Stats().Expired)
+	})
+
+	t.Run("delayed", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithDefaultTTL(50 * time.Millisecond))
+		q.PushAfter(func() { atomic.AddInt64(&sum, 1) }, 100*time.Millisecond)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(1), atomic.LoadInt64(&sum))
+		as.Equal(uint64(0), q.Stats().Expired)
+	})
+
+	t.Run("keyed serial", func(t *testing.T) {
+		var list []int
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(2), WithKeyedSerial())
+		q.Push(func() { <-ch; list = append(list, 0) }, 1)
+		as.NoError(q.PushContext(WithJobTTL(context.Background(), time.Millisecond), func() { list = append(list, 1) }, 1))
+		as.NoError(q.PushContext(context.Background(), func() { list = append(list, 2) }, 1))
+		time.Sleep(10 * time.Millisecond)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{0, 2}, list)
+	})
+
+	t.Run("wake blocked producer", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(WithConcurrency(1), WithCapacity(2))
+		q.Push(func() { <-ch })
+		var ctx = WithJobTTL(context.Background(), 5*time.Millisecond)
+		as.NoError(q.PushContext(ctx, func() {}))
+		as.NoError(q.PushContext(ctx, func() {}))
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			close(ch)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		as.NoError(q.PushContext(ctx, func() {}))
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(uint64(2), q.Stats().Expired)
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		var sum = int64(0)
+		var ch = make(chan struct{})
+		q := New(WithSharding(4), WithConcurrency(1), WithWorkStealing(), WithDefaultTTL(5*time.Millisecond))
+		for i := 0; i < 4; i++ {
+			q.Push(func() { <-ch }, int64(i))
+		}
+		for i := 0; i < 40; i++ {
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		time.Sleep(10 * time.Millisecond)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(0), atomic.LoadInt64(&sum))
+		as.Equal(uint64(40), q.Stats().Expired)
+	})
+}
+
+func TestJobTimeout(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("cancel context", func(t *testing.T) {
+		var reports = make(chan TimedOutJob, 4)
+		q := New(
+			WithJobTimeout(10*time.Millisecond),
+			WithOnJobTimeout(func(job TimedOutJob) { reports <- job }),
+		)
+		var err error
+		q.PushWithContext(context.Background(), func(ctx context.Context) {
+			<-ctx.Done()
+			err = ctx.Err()
+		})
+		as.NoError(q.Stop(context.Background()))
+		as.ErrorIs(err, context.Canceled)
+		var report = <-reports
+		as.False(report.Abandoned)
+		as.Equal(10*time.Millisecond, report.Timeout)
+		as.Equal(uint64(1), q.Stats().TimedOut)
+		as.Equal(uint64(0), q.Stats().Abandoned)
+		as.Equal(uint64(1), q.Stats().Completed)
+	})
+
+	t.Run("abandon", func(t *testing.T) {
+		var reports = make(chan TimedOutJob, 4)
+		var ch = make(chan struct{})
+		var done = make(chan struct{})
+		q := New(
+			WithConcurrency(1),
+			WithJobTimeout(5*time.Millisecond),
+			WithTimeoutGrace(5*time.Millisecond),
+			WithOnJobTimeout(func(job TimedOutJob) { reports <- job }),
+		)
+		q.Push(func() { <-ch }, 1)
+		q.Push(func() { close(done) })
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			as.Fail("slot not released")
+		}
+
+		as.False((<-reports).Abandoned)
+		var report = <-reports
+		as.True(report.Abandoned)
+		as.Equal([]int64{1}, report.Hashcode)
+		var stats = q.Stats()
+		as.Equal(uint64(1), stats.Abandoned)
+		as.Equal(0, stats.Running)
+		as.Len(stats.Stuck, 1)
+
+		as.NoError(q.Stop(context.Background()))
+		close(ch)
+		time.Sleep(10 * time.Millisecond)
+		stats = q.Stats()
+		as.Len(stats.Stuck, 0)
+		as.Equal(uint64(2), stats.Completed)
+	})
+
+	t.Run("per push", func(t *testing.T) {
+		var sum = int64(0)
+		q := New(WithJobTimeout(time.Millisecond))
+		var ctx = WithJobBudget(context.Background(), time.Hour)
+		q.PushWithContext(ctx, func(ctx context.Context) {
+			time.Sleep(10 * time.Millisecond)
+			if ctx.Err() == nil {
+				atomic.AddInt64(&sum, 1)
+			}
+		})
+		ctx = WithJobBudget(context.Background(), 0)
+		q.PushWithContext(ctx, func(ctx context.Context) {
+			time.Sleep(10 * time.Millisecond)
+			if ctx.Err() == nil {
+				atomic.AddInt64(&sum, 1)
+			}
+		})
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(int64(2), atomic.LoadInt64(&sum))
+		as.Equal(uint64(0), q.Stats().TimedOut)
+	})
+
+	t.Run("caller runs", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(
+			WithConcurrency(1),
+			WithCapacity(1),
+			WithOverflowPolicy(OverflowCallerRuns),
+			WithJobTimeout(5*time.Millisecond),
+			WithTimeoutGrace(time.Millisecond),
+		)
+		as.NoError(q.PushContext(WithJobBudget(context.Background(), 0), func() { <-ch }))
+		q.Push(func() {})
+		q.Push(func() { time.Sleep(20 * time.Millisecond) })
+		as.Equal(uint64(1), q.Stats().Abandoned)
+		as.Len(q.Stats().Stuck, 0)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal(uint64(3), q.Stats().Completed)
+	})
+
+	t.Run("multiple queue", func(t *testing.T) {
+		var ch = make(chan struct{})
+		q := New(
+			WithSharding(2),
+			WithConcurrency(1),
+			WithJobTimeout(time.Millisecond),
+			WithTimeoutGrace(time.Millisecond),
+		)
+		q.Push(func() { <-ch }, 0)
+		q.Push(func() { <-ch }, 1)
+		time.Sleep(20 * time.Millisecond)
+		var stats = q.Stats()
+		as.Equal(uint64(2), stats.TimedOut)
+		as.Equal(uint64(2), stats.Abandoned)
+		as.Len(stats.Stuck, 2)
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+	})
+}
+
+func TestLockFreePush(t *testing.T) {
+	as := assert.New(t)
+
+	t.Run("push during stop", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			q := New(WithConcurrency(2), WithSharding(1))
+			var accepted, executed int64
+			var wg sync.WaitGroup
+			for j := 0; j < 8; j++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for k := 0; k < 200; k++ {
+						if q.PushContext(context.Background(), func() { atomic.AddInt64(&executed, 1) }) == nil {
+							atomic.AddInt64(&accepted, 1)
+						}
+					}
+				}()
+			}
+			time.Sleep(time.Millisecond)
+			as.NoError(q.Stop(context.Background()))
+			wg.Wait()
+			as.Equal(atomic.LoadInt64(&accepted), atomic.LoadInt64(&executed))
+		}
+	})
+
+	t.Run("wake up", func(t *testing.T) {
+		q := New(WithConcurrency(1), WithSharding(1))
+		for i := 0; i < 1000; i++ {
+			var ch = make(chan struct{})
+			q.Push(func() {})
+			q.Push(func() { close(ch) })
+			select {
+			case <-ch:
+			case <-time.After(time.Second):
+				t.Fatal("job not executed")
+			}
+		}
+		as.NoError(q.Stop(context.Background()))
+	})
+
+	t.Run("mixed order", func(t *testing.T) {
+		var ch = make(chan struct{})
+		var mu sync.Mutex
+		var list []string
+		var add = func(s string) func() {
+			return func() {
+				mu.Lock()
+				list = append(list, s)
+				mu.Unlock()
+			}
+		}
+		q := New(WithConcurrency(1), WithSharding(1), WithKeyedSerial())
+		q.Push(func() { <-ch })
+		q.Push(add("A"))
+		as.NoError(q.PushUnique(context.Background(), "b", add("B")))
+		q.Push(add("C"), 1)
+		q.PushAfter(add("D"), time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+		q.Push(add("E"))
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]string{"A", "B", "C", "D", "E"}, list)
+	})
+
+	t.Run("order", func(t *testing.T) {
+		var ch = make(chan struct{})
+		var list []int
+		q := New(WithConcurrency(1), WithSharding(1))
+		q.Push(func() { <-ch })
+		for i := 0; i < 10; i++ {
+			var v = i
+			q.Push(func() { list = append(list, v) })
+		}
+		as.Equal(10, q.Len())
+		close(ch)
+		as.NoError(q.Stop(context.Background()))
+		as.Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, list)
+	})
+
+	t.Run("plain jobs", func(t *testing.T) {
+		var sink = NewMemoryDeadLetters(0)
+		q := New(
+			WithConcurrency(2),
+			WithSharding(1),
+			WithRecovery(),
+			WithLogger(&testLogger{}),
+			WithDeadLetter(sink),
+		)
+		var sum int64
+		for i := 0; i < 1000; i++ {
+			if i%100 == 0 {
+				q.Push(func() { panic("test") })
+				continue
+			}
+			q.Push(func() { atomic.AddInt64(&sum, 1) })
+		}
+		as.NoError(q.Stop(context.Background()))
+		q.Push(func() { atomic.AddInt64(&sum, 1) })
+		as.Equal(int64(990), atomic.LoadInt64(&sum))
+
+		var s = q.Stats()
+		as.Equal(uint64(1000), s.Completed)
+		as.Equal(uint64(10), s.Panicked)
+		as.Equal(uint64(0), s.ExecLatency.Count)
+		var letters = sink.Letters()
+		as.Len(letters, 10)
+		as.Equal(ErrJobPanic, letters[0].Err)
+		as.False(letters[0].FailedAt.IsZero())
+	})
+}
@@ -0,0 +1,116 @@
+package queues
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Clock 时钟, 用于限流器计时和唤醒, 测试时可以替换为手动推进的时钟
+	Clock interface {
+		// Now 当前时间
+		Now() time.Time
+
+		// AfterFunc 在 d 之后调用 f
+		AfterFunc(d time.Duration, f func()) Timer
+	}
+
+	// Timer 由 Clock.AfterFunc 创建的定时器
+	Timer interface {
+		// Stop 停止定时器, 返回定时器是否在触发前被停止
+		Stop() bool
+	}
+
+	// 系统时钟
+	systemClock struct{}
+
+	// 令牌桶限流器, 线程安全, 可以由多个分片共享
+	rateLimiter struct {
+		mu     sync.Mutex
+		clock  Clock
+		rate   float64   // 每秒生成的令牌数量
+		burst  float64   // 令牌桶容量
+		tokens float64   // 剩余令牌数量
+		last   time.Time // 上次更新令牌的时间
+	}
+)
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// 创建限流器, 令牌桶初始是满的
+func newRateLimiter(rate float64, burst int, clock Clock) *rateLimiter {
+	return &rateLimiter{
+		clock:  clock,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clock.Now(),
+	}
+}
+
+// 取走一个令牌, 令牌不足时返回需要等待的时长
+func (c *rateLimiter) take() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var now = c.clock.Now()
+	if elapsed := now.Sub(c.last); elapsed > 0 {
+		c.tokens += elapsed.Seconds() * c.rate
+		if c.tokens > c.burst {
+			c.tokens = c.burst
+		}
+	}
+	c.last = now
+
+	if c.tokens >= 1 {
+		c.tokens--
+		return 0, true
+	}
+	var d = time.Duration((1 - c.tokens) / c.rate * float64(time.Second))
+	if d <= 0 {
+		d = time.Nanosecond
+	}
+	return d, false
+}
+
+// 归还一个未使用的令牌
+func (c *rateLimiter) put() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens++; c.tokens > c.burst {
+		c.tokens = c.burst
+	}
+}
+
+// 在限流内占用一个令牌, 令牌不足时等待下一个令牌生成后再次分派任务, 调用方需持有锁
+func (c *singleQueue) acquire() bool {
+	return c.limiter == nil || c.takeToken()
+}
+
+// 从限流器取一个令牌, 调用方需持有锁
+func (c *singleQueue) takeToken() bool {
+	d, ok := c.limiter.take()
+	if !ok && c.throttle == nil {
+		c.throttle = c.conf.clock.AfterFunc(d, c.onToken)
+	}
+	return ok
+}
+
+// 归还未使用的令牌, 调用方需持有锁
+func (c *singleQueue) refund() {
+	if c.limiter != nil {
+		c.limiter.put()
+	}
+}
+
+// 限流定时器回调, 分派限流期间积压的任务
+func (c *singleQueue) onToken() {
+	c.mu.Lock()
+	c.throttle = nil
+	var jobs = c.takeJobs(time.Now().UnixNano())
+	c.mu.Unlock()
+
+	c.spawn(jobs)
+}
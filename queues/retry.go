@@ -0,0 +1,56 @@
+package queues
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/lxzan/concurrency/internal"
+)
+
+// RetryPolicy 重试策略, 仅对 ErrorJob 有效
+// 重试的任务按退避时长放入延迟任务队列, 等待期间不占用并发槽位
+type RetryPolicy struct {
+	// MaxAttempts 最大执行次数(包含首次执行), 不大于1时不重试
+	MaxAttempts int
+
+	// InitialBackoff 首次重试前的等待时长, 默认100ms
+	InitialBackoff time.Duration
+
+	// MaxBackoff 最大等待时长, 加上随机抖动后也不会超过, 为0或者超过24h时按24h计算
+	MaxBackoff time.Duration
+
+	// Multiplier 每次重试等待时长的增长倍数, 默认为2
+	Multiplier float64
+
+	// Jitter 随机抖动比例, 取值 [0, 1], 等待时长在 [1-Jitter, 1+Jitter] 倍之间随机, 避免大量任务同时重试
+	Jitter float64
+
+	// Retryable 判断错误是否可以重试, 为空时所有错误都可以重试
+	Retryable func(err error) bool
+}
+
+// WithRetry 设置 ErrorJob 返回错误时的重试策略
+// 开启顺序模式时, 重试期间同一 hashcode 的后续任务继续等待, 保证顺序
+func WithRetry(policy RetryPolicy) Option {
+	return func(o *options) {
+		policy.InitialBackoff = internal.SelectValue(policy.InitialBackoff <= 0, defaultInitialBackoff, policy.InitialBackoff)
+		policy.Multiplier = internal.SelectValue(policy.Multiplier < 1, defaultMultiplier, policy.Multiplier)
+		policy.Jitter = math.Min(math.Max(policy.Jitter, 0), 1)
+		o.retry = &policy
+	}
+}
+
+// 计算第 attempt 次执行失败后的重试等待时长, 不重试时返回 false
+func (c *RetryPolicy) backoff(attempt int, err error) (time.Duration, bool) {
+	if c == nil || attempt >= c.MaxAttempts || (c.Retryable != nil && !c.Retryable(err)) {
+		return 0, false
+	}
+	// 先限制等待时长再转换, 避免指数增长溢出 time.Duration
+	var limit = internal.SelectValue(c.MaxBackoff > 0 && c.MaxBackoff < maxBackoff, c.MaxBackoff, maxBackoff)
+	var d = math.Min(float64(c.InitialBackoff)*math.Pow(c.Multiplier, float64(attempt-1)), float64(limit))
+	if c.Jitter > 0 {
+		d = math.Min(d*(1+c.Jitter*(2*rand.Float64()-1)), float64(limit))
+	}
+	return time.Duration(d), true
+}
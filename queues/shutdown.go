@@ -0,0 +1,153 @@
+package queues
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StopMode 停止方式
+type StopMode uint8
+
+const (
+	// StopDrain 继续执行剩余任务, 直到全部完成或者超时, 与 Stop 相同
+	StopDrain StopMode = iota
+
+	// StopDiscard 立即丢弃剩余任务并取消任务上下文, 只等待正在执行的任务; 等待期间产生的重试任务同样被丢弃
+	StopDiscard
+
+	// StopHandBack 立即取出剩余任务并返回, 同时取消任务上下文, 只等待正在执行的任务
+	StopHandBack
+)
+
+type (
+	// StopResult 停止的结果
+	StopResult struct {
+		Completed int          // 停止期间执行完成的任务数量
+		Dropped   int          // 被丢弃的任务数量
+		Running   int          // 返回时仍在执行的任务数量
+		Jobs      []PendingJob // StopHandBack 模式下取出的未执行任务
+	}
+
+	// PendingJob 未执行的任务
+	// StopHandBack 取出的持久化任务已从预写日志中移除, 由调用方负责重新追加, 例如通过 Requeue
+	PendingJob struct {
+		Job      any       // 原任务, 类型为 Job, ContextJob 或 ErrorJob
+		Name     string    // 持久化任务的处理函数名称
+		Payload  []byte    // 持久化任务的参数
+		Hashcode []int64   // 追加任务时指定的 hashcode
+		Priority int       // 优先级
+		At       time.Time // 延迟任务或者等待重试的任务的预定执行时间
+		Attempts int       // 已执行失败的次数
+		LimitKey string    // 限制键
+		Unique   string    // 去重任务的键
+	}
+)
+
+// Requeue 将未执行的任务追加到任务队列, 可用于将 StopHandBack 取出的任务转移到另一个队列
+// Job 类型的延迟任务保持预定执行时间, 其它类型的任务立即执行
+func Requeue(ctx context.Context, q Queue, jobs ...PendingJob) error {
+	var errs []error
+	for _, job := range jobs {
+		var jobCtx = ctx
+		if job.LimitKey != "" {
+			jobCtx = WithLimitKey(ctx, job.LimitKey)
+		}
+		if j, ok := job.Job.(Job); ok && job.Unique != "" {
+			if err := q.PushUnique(jobCtx, job.Unique, j, job.Hashcode...); err != nil && !errors.Is(err, ErrDuplicateJob) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if j, ok := job.Job.(Job); ok && job.Name == "" && !job.At.IsZero() {
+			q.PushAt(j, job.At, job.Hashcode...)
+			continue
+		}
+		if err := pushJob(jobCtx, q, job.Job, job.Name, job.Payload, job.Hashcode, job.Priority); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 按任务类型追加任务, 持久化任务通过 PushDurable 追加, 优先级通过上下文传递
+func pushJob(ctx context.Context, q Queue, job any, name string, payload []byte, hashcode []int64, priority int) error {
+	if priority != 0 {
+		ctx = WithJobPriority(ctx, priority)
+	}
+	if name != "" {
+		return q.PushDurable(ctx, name, payload, hashcode...)
+	}
+	switch v := job.(type) {
+	case Job:
+		return q.PushContext(ctx, v, hashcode...)
+	case ContextJob:
+		return q.PushWithContext(ctx, v, hashcode...)
+	case ErrorJob:
+		return q.PushErrorJob(ctx, v, hashcode...)
+	default:
+		return ErrNotRedrivable
+	}
+}
+
+// 交还未执行的任务, 调用方需持有锁, 释放锁后调用 forget 将其中的持久化任务从预写日志中移除
+func (c *singleQueue) handBack(eles []*element, jobs []PendingJob) []PendingJob {
+	for _, ele := range eles {
+		ele.drop(ErrQueueStopped)
+		jobs = append(jobs, ele.pending())
+	}
+	return jobs
+}
+
+// 将交还的持久化任务从预写日志中移除, 避免重新追加后重启时再次恢复执行
+// 写日志可能阻塞, 调用方不能持有锁
+func (c *singleQueue) forget(eles []*element) {
+	for _, ele := range eles {
+		if ele.durable == nil {
+			continue
+		}
+		if err := c.conf.wal.done(ele.durable.ID); err != nil {
+			c.conf.logger.Errorf("queues: failed to hand back job %d: %v", ele.durable.ID, err)
+		}
+	}
+}
+
+// 原任务
+func (c *element) origin() any {
+	switch {
+	case c.errJob != nil:
+		return c.errJob
+	case c.ctxJob != nil:
+		return c.ctxJob
+	case c.unique != nil:
+		return c.unique.job
+	default:
+		return c.job
+	}
+}
+
+// 转换为未执行的任务
+func (c *element) pending() PendingJob {
+	var job = PendingJob{Job: c.origin(), Priority: c.priority, Attempts: c.attempt, LimitKey: c.limitKey}
+	if c.at > 0 {
+		job.At = time.Unix(0, c.at)
+	}
+	if c.hashed {
+		job.Hashcode = []int64{c.key}
+	}
+	if c.durable != nil {
+		job.Name, job.Payload = c.durable.Name, c.durable.Payload
+	}
+	if c.unique != nil {
+		job.Unique = c.unique.key
+	}
+	return job
+}
+
+// 聚合另一个分片的结果
+func (c *StopResult) merge(r StopResult) {
+	c.Completed += r.Completed
+	c.Dropped += r.Dropped
+	c.Running += r.Running
+	c.Jobs = append(c.Jobs, r.Jobs...)
+}
@@ -2,110 +2,1113 @@ package queues
 
 import (
 	"context"
+	"math"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/lxzan/dao/deque"
+	"github.com/lxzan/concurrency/internal"
+	"github.com/lxzan/concurrency/tracing"
 )
 
 // 创建一条任务队列
 func newSingleQueue(o *options) *singleQueue {
-	return &singleQueue{
-		conf:           o,
-		maxConcurrency: int32(o.concurrency),
-		q:              deque.New[Job](8),
+	c := &singleQueue{
+		conf:    o,
+		q:       newContainer(o),
+		delayed: newDelayQueue(),
+		serial:  make(map[int64]*internal.Ring[*element]),
+		unique:  make(map[string]*uniqueJob),
+		stuck:   make(map[*worker]TimedOutJob),
 	}
+	c.maxConcurrency.Store(int32(o.concurrency))
+	c.lockFree = o.lockFree()
+	c.timed = o.timed()
+	if fifo, ok := c.q.(*fifoContainer); ok && c.lockFree && !c.timed && o.ttl <= 0 {
+		c.plain = fifo
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	if o.workerPool {
+		c.ready = make(chan *element)
+	}
+	if c.limiter = o.limiter; o.rate > 0 && o.limiter == nil {
+		c.limiter = newRateLimiter(o.rate, o.burst, o.clock)
+	}
+	if o.keyLimit != nil {
+		c.keys = newKeyLimits(*o.keyLimit)
+	}
+	return c
 }
 
 type singleQueue struct {
 	mu             sync.Mutex // 锁
 	conf           *options
-	q              *deque.Deque[Job] // 任务队列
-	maxConcurrency int32             // 最大并发
-	curConcurrency int32             // 当前并发
-	stopped        bool              // 是否关闭
+	ctx            context.Context                    // 任务上下文, 停止超时后取消
+	cancel         context.CancelFunc                 // 取消函数
+	q              container                          // 任务队列
+	delayed        *delayQueue                        // 延迟任务队列
+	serial         map[int64]*internal.Ring[*element] // 顺序键积压队列
+	backlog        int                                // 积压任务数量
+	parked         int                                // 等待键的令牌才能开始执行的任务数量
+	siblings       []*singleQueue                     // 所有分片, 仅在开启任务窃取时有效
+	probe          atomic.Uint32                      // 窃取时的探测序号
+	stats          queueStats                         // 统计
+	notFull        chan struct{}                      // 队列非满信号, 有生产者等待时才创建
+	maxConcurrency atomic.Int32                       // 最大并发, 加锁修改, 无锁追加时原子读取
+	curConcurrency atomic.Int32                       // 当前并发, 加锁修改, 无锁追加时原子读取
+	paused         bool                               // 是否暂停
+	stopped        bool                               // 是否关闭
+	closed         atomic.Bool                        // 是否关闭, 供无锁追加读取
+	inbox          atomic.Pointer[element]            // 无锁追加的任务, 按追加顺序的逆序链接
+	lockFree       bool                               // 是否允许无锁追加
+	timed          bool                               // 是否记录每个任务的时间
+	plain          *fifoContainer                     // 非空时 Push 跳过上下文和时钟, 直接追加任务函数
+	mode           StopMode                           // 停止方式
+	leftover       []*element                         // 停止期间产生的待重试任务, 仅在非 StopDrain 方式下有效
+	drained        chan struct{}                      // 停止后全部任务完成的信号, 发出信号后置空
+	ready          chan *element                      // 交给空闲常驻协程的任务, 仅在开启常驻协程池时有效
+	idle           atomic.Int32                       // 空闲的常驻协程数量
+	limiter        *rateLimiter                       // 限流器, 全局限流时由所有分片共享
+	throttle       Timer                              // 等待令牌的定时器, 令牌不足且有任务等待分派时创建
+	keys           *keyLimits                         // 按键限制的状态, 未开启时为空
+	unique         map[string]*uniqueJob              // 等待执行的去重任务
+	stuck          map[*worker]TimedOutJob            // 超时后忽略了取消仍在执行的任务
 }
 
 func (c *singleQueue) Stop(ctx context.Context) error {
-	if !c.cas(false, true) {
-		return nil
+	_, err := c.Shutdown(ctx, StopDrain)
+	return err
+}
+
+// Shutdown 按指定方式停止
+func (c *singleQueue) Shutdown(ctx context.Context, mode StopMode) (StopResult, error) {
+	var st, ok = c.stop(mode)
+	if !ok {
+		return StopResult{}, nil
 	}
 
 	ctx1, cancel := context.WithTimeout(ctx, c.conf.timeout)
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer func() {
-		cancel()
-		ticker.Stop()
-	}()
+	defer cancel()
+	var err = c.wait(ctx1, st)
+	return c.settle(st), err
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			if c.finish() {
-				return nil
+// 停止过程的状态
+type stopping struct {
+	result    StopResult    // 停止的结果
+	completed uint64        // 开始停止时已完成的任务数量
+	drained   chan struct{} // 剩余任务和执行中的任务全部完成时关闭
+}
+
+// 标记为已停止并按停止方式处理剩余任务, 已经停止时返回 false
+func (c *singleQueue) stop(mode StopMode) (*stopping, bool) {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.closed.Store(true)
+	c.collect()
+	c.stopped = true
+	c.mode = mode
+	c.drained = make(chan struct{})
+	var st = &stopping{completed: c.stats.completed, drained: c.drained}
+	var jobs, handed []*element
+	switch {
+	case mode == StopHandBack:
+		handed = c.clear()
+		st.result.Jobs = c.handBack(handed, st.result.Jobs)
+	case mode == StopDiscard:
+		var eles = c.clear()
+		for i := range eles {
+			eles[i].drop(ErrQueueStopped)
+		}
+		st.result.Dropped = len(eles)
+		c.stats.dropped += uint64(len(eles))
+	case c.conf.discardDelayed:
+		st.result.Dropped = c.discardDelayed()
+		c.stats.dropped += uint64(st.result.Dropped)
+		jobs = c.takeJobs(time.Now().UnixNano())
+	}
+	c.signal()
+	c.checkDrained()
+	c.mu.Unlock()
+	c.forget(handed)
+	if mode != StopDrain {
+		// 不再等待剩余任务, 立即取消任务上下文, 正在执行的任务可以尽快返回
+		c.cancel()
+	}
+	c.spawn(jobs)
+	return st, true
+}
+
+// 等待剩余任务和执行中的任务全部完成, 超时返回上下文错误
+func (c *singleQueue) wait(ctx context.Context, st *stopping) error {
+	select {
+	case <-st.drained:
+		return nil
+	case <-ctx.Done():
+		if c.finish() {
+			return nil
+		}
+		return ctx.Err()
+	}
+}
+
+// 汇总停止的结果并取消任务上下文
+func (c *singleQueue) settle(st *stopping) StopResult {
+	defer c.cancel()
+	c.mu.Lock()
+	var result = st.result
+	var handed []*element
+	if c.mode != StopDrain {
+		var leftover = append(c.leftover, c.clear()...)
+		c.leftover = nil
+		if c.mode == StopHandBack {
+			handed = leftover
+			result.Jobs = c.handBack(leftover, result.Jobs)
+		} else {
+			for i := range leftover {
+				leftover[i].drop(ErrQueueStopped)
 			}
-		case <-ctx1.Done():
-			if c.finish() {
-				return nil
+			result.Dropped += len(leftover)
+			c.stats.dropped += uint64(len(leftover))
+		}
+	}
+	result.Completed = int(c.stats.completed - st.completed)
+	result.Running = int(c.curConcurrency.Load())
+	c.mu.Unlock()
+	c.forget(handed)
+	return result
+}
+
+// 停止后剩余任务和执行中的任务全部完成时发出信号, 调用方需持有锁
+func (c *singleQueue) checkDrained() {
+	if c.drained != nil && c.size()+int(c.curConcurrency.Load()) == 0 {
+		close(c.drained)
+		c.drained = nil
+	}
+}
+
+// 释放一个并发槽位并获取下一个任务
+// finished 为刚执行完一个任务的 worker
+// 取到任务时沿用释放的槽位, 不修改并发计数; 取不到时减少并发后重新检查无锁追加的任务
+func (c *singleQueue) getJob(finished *worker) (*element, bool) {
+	c.mu.Lock()
+	var now int64
+	if finished != nil {
+		c.complete(finished)
+		now = finished.end
+	} else {
+		now = time.Now().UnixNano()
+	}
+	ele, ok := c.take(now, true)
+	if !ok {
+		c.curConcurrency.Add(-1)
+		if ele, ok = c.takeJob(now); !ok {
+			c.checkDrained()
+		}
+	}
+	c.mu.Unlock()
+	return ele, ok
+}
+
+// 在并发限制内取出一个任务, 调用方需持有锁
+// now 为当前时间, 由调用方传入以减少取时间的开销
+// 过了截止时间的任务被跳过
+func (c *singleQueue) takeJob(now int64) (ele *element, ok bool) {
+	return c.take(now, false)
+}
+
+// 取出一个任务, reuse 表示调用方持有一个正在释放的并发槽位, 取到任务时沿用该槽位, 调用方需持有锁
+func (c *singleQueue) take(now int64, reuse bool) (ele *element, ok bool) {
+	c.collect()
+	var cur = c.curConcurrency.Load()
+	if reuse {
+		cur--
+	}
+	if c.paused || cur >= c.maxConcurrency.Load() || c.q.Len()+c.parked == 0 || !c.acquire() {
+		return ele, false
+	}
+	var expired []*element
+	for ele, ok = c.pop(&now); ok && ele.expired(&now); ele, ok = c.pop(&now) {
+		expired = c.expire(ele, expired)
+	}
+	if ok {
+		if !reuse {
+			c.curConcurrency.Add(1)
+		}
+		c.dequeued(ele, now)
+	} else {
+		c.refund()
+		c.checkDrained()
+	}
+	if len(expired) > 0 {
+		go c.notifyExpired(expired)
+	}
+	return ele, ok
+}
+
+// 弹出下一个要执行的任务, 先取令牌已经生成的等待任务, 调用方需持有锁
+// 过了截止时间的任务不检查键的令牌, 由调用方丢弃
+func (c *singleQueue) pop(now *int64) (*element, bool) {
+	if c.keys == nil {
+		return c.q.Pop()
+	}
+	if ele, ok := c.unpark(now); ok {
+		return ele, true
+	}
+	for {
+		ele, ok := c.q.Pop()
+		if !ok || ele.expired(now) || c.pace(ele) {
+			return ele, ok
+		}
+	}
+}
+
+// 任务离开任务队列, 调用方需持有锁
+// now 为0时表示调用方尚未读取时钟
+func (c *singleQueue) dequeued(ele *element, now int64) {
+	c.releaseUnique(ele)
+	if c.timed {
+		ele.startedAt = readNow(&now)
+		if c.conf.latency {
+			c.stats.wait.Observe(time.Duration(now - ele.pushedAt))
+		}
+	}
+	c.signal()
+}
+
+// 任务执行结束, 调用方需持有锁
+// 需要重试的任务放入延迟任务队列, 继续持有顺序键, 存活时长从重试时间开始重新计算; 否则释放顺序键
+func (c *singleQueue) complete(w *worker) {
+	c.record(w)
+	if w.retryAt > 0 {
+		var ele = w.ele
+		ele.attempt++
+		ele.at = w.retryAt
+		ele.deadline = c.deadline(ele.ctx, ele.at, &w.end)
+		c.stats.retried++
+		if c.stopped && c.mode != StopDrain {
+			c.leftover = append(c.leftover, ele)
+			c.release(ele)
+			return
+		}
+		c.delayed.Push(ele)
+		c.delayed.Reset(w.end, c.onTimer)
+		return
+	}
+	if w.err != nil {
+		c.stats.failed++
+	}
+	if w.ele.plain {
+		// 只有任务函数的任务不持有顺序键和限制键, 直接回收
+		c.plain.recycle(w.ele)
+		w.ele = nil
+		return
+	}
+	c.release(w.ele)
+}
+
+// 记录任务执行结果, 调用方需持有锁
+func (c *singleQueue) record(w *worker) {
+	c.stats.completed++
+	if w.panicked {
+		c.stats.panicked++
+	}
+	if c.conf.latency {
+		c.stats.exec.Observe(w.elapsed)
+	}
+}
+
+// 循环执行任务
+func (c *singleQueue) do(ele *element) {
+	var w = newWorker(c)
+	for ok := true; ok; ele, ok = w.park() {
+		for ; ok; ele, ok = c.next(w) {
+			if w.exec(ele); w.abandoned {
+				c.settleAbandoned(w)
+				return
 			}
-			return ctx1.Err()
 		}
 	}
 }
 
-// 获取一个任务
-func (c *singleQueue) getJob(newJob Job, delta int32) Job {
+// 执行任务, 优先交给空闲的常驻协程, 没有空闲协程时创建新的协程
+func (c *singleQueue) launch(ele *element) {
+	if c.ready != nil {
+		select {
+		case c.ready <- ele:
+			return
+		default:
+		}
+	}
+	go c.do(ele)
+}
+
+// 获取下一个任务, 本分片没有任务时尝试从其它分片窃取
+func (c *singleQueue) next(finished *worker) (*element, bool) {
+	ele, ok := c.getJob(finished)
+	if ok || len(c.siblings) == 0 {
+		return ele, ok
+	}
+	return c.steal()
+}
+
+// 占用一个并发槽位, 从其它分片窃取一个任务
+// 窃取失败时释放槽位, 并检查本分片在此期间是否有新任务
+func (c *singleQueue) steal() (ele *element, ok bool) {
+	c.mu.Lock()
+	if c.paused || c.curConcurrency.Load() >= c.maxConcurrency.Load() {
+		c.mu.Unlock()
+		return ele, false
+	}
+	c.curConcurrency.Add(1)
+	c.mu.Unlock()
+
+	var n = len(c.siblings)
+	var offset = int(c.probe.Add(1))
+	for i := 0; i < n; i++ {
+		var q = c.siblings[(offset+i)%n]
+		if q == c {
+			continue
+		}
+		if ele, ok = q.giveJob(); ok {
+			return ele, true
+		}
+	}
+	return c.getJob(nil)
+}
+
+// 被其它分片窃取一个任务
+func (c *singleQueue) giveJob() (ele *element, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.paused || c.q.Len() == 0 || !c.acquire() {
+		return ele, false
+	}
+	var now = time.Now().UnixNano()
+	var expired []*element
+	for ele, ok = c.q.Steal(); ok && ele.expired(&now); ele, ok = c.q.Steal() {
+		expired = c.expire(ele, expired)
+	}
+	if ok {
+		c.dequeued(ele, now)
+	} else {
+		c.refund()
+	}
+	c.checkDrained()
+	if len(expired) > 0 {
+		go c.notifyExpired(expired)
+	}
+	return ele, ok
+}
+
+// 唤醒一个分片来窃取任务, 每次只探测一个分片以降低锁竞争
+func (c *singleQueue) nudge() {
+	var q = c.siblings[int(c.probe.Add(1))%len(c.siblings)]
+	if q == c {
+		return
+	}
+	if ele, ok := q.steal(); ok {
+		q.launch(ele)
+	}
+}
+
+// 在调用方协程中执行任务
+func (c *singleQueue) callerRuns(ele *element) {
+	var w = newWorker(c)
+	w.inline = true
+	ele.startedAt = time.Now().UnixNano()
+	ele.pushedAt = ele.startedAt
+	w.exec(ele)
+	c.mu.Lock()
+	delete(c.stuck, w)
+	c.complete(w)
+	c.mu.Unlock()
+}
+
+// Push 追加任务, 有资源空闲的话会立即执行
+// 队列已满时按溢出策略处理, 被拒绝的任务会被丢弃
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) Push(job Job, hashcode ...int64) {
+	if c.plain != nil && job != nil && len(hashcode) == 0 && len(c.siblings) == 0 {
+		c.pushJob(job)
+		return
+	}
+	_ = c.push(context.Background(), &element{job: job}, true, hashcode)
+}
+
+// 追加只有任务函数的任务, 不创建元素, 弹出时才绑定元素
+// 并发已满时任务只能等待正在执行的任务结束后取出, 不需要尝试分派
+func (c *singleQueue) pushJob(job Job) {
+	c.mu.Lock()
+	c.collect()
+	if c.stopped {
+		c.mu.Unlock()
+		return
+	}
+	c.plain.PushJob(job)
+	if c.curConcurrency.Load() >= c.maxConcurrency.Load() {
+		c.mu.Unlock()
+		return
+	}
+	ele, ok := c.takeJob(0)
+	c.mu.Unlock()
+	if ok {
+		c.launch(ele)
+	}
+}
+
+// PushPriority 追加带优先级的任务
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushPriority(job Job, priority int, hashcode ...int64) {
+	_ = c.push(context.Background(), &element{job: job, priority: priority}, true, hashcode)
+}
+
+// PushContext 追加任务, 返回任务被拒绝的原因
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushContext(ctx context.Context, job Job, hashcode ...int64) error {
+	return c.push(ctx, &element{job: job}, true, hashcode)
+}
+
+// TryPush 尝试追加任务, 不会阻塞
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) TryPush(job Job, hashcode ...int64) bool {
+	return c.push(context.Background(), &element{job: job}, false, hashcode) == nil
+}
+
+// PushContextJob 追加携带上下文的任务
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushContextJob(job ContextJob, hashcode ...int64) {
+	_ = c.push(context.Background(), &element{ctxJob: job}, true, hashcode)
+}
+
+// PushWithContext 追加携带上下文的任务, 返回任务被拒绝的原因
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushWithContext(ctx context.Context, job ContextJob, hashcode ...int64) error {
+	return c.push(ctx, &element{ctxJob: job}, true, hashcode)
+}
+
+// PushErrorJob 追加返回错误的任务, 返回任务被拒绝的原因
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushErrorJob(ctx context.Context, job ErrorJob, hashcode ...int64) error {
+	return c.push(ctx, &element{errJob: job}, true, hashcode)
+}
+
+// PushDurable 追加持久化任务, 返回任务被拒绝的原因
+// 任务被拒绝时从预写日志中移除
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushDurable(ctx context.Context, name string, payload []byte, hashcode ...int64) error {
+	if c.conf.wal == nil {
+		return ErrNoWAL
+	}
+	if _, ok := c.conf.handlers[name]; !ok {
+		return ErrUnknownHandler
+	}
+	var key string
+	if c.conf.keyLimit != nil {
+		key = limitKey(ctx)
+	}
+	record, err := c.conf.wal.add(name, payload, hashcode, key)
+	if err != nil {
+		return err
+	}
+	if err = c.pushRecord(ctx, record); err != nil {
+		_ = c.conf.wal.done(record.ID)
+	}
+	return err
+}
+
+// 追加预写日志中的任务
+func (c *singleQueue) pushRecord(ctx context.Context, record walRecord) error {
+	handler, ok := c.conf.handlers[record.Name]
+	if !ok {
+		return ErrUnknownHandler
+	}
+	var payload = record.Payload
+	var ele = &element{
+		durable: &record,
+		errJob:  func(ctx context.Context) error { return handler(ctx, payload) },
+	}
+	return c.push(ctx, ele, true, record.Hashcode)
+}
+
+// PushAfter 追加延迟任务, 等待 d 之后才能执行
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushAfter(job Job, d time.Duration, hashcode ...int64) {
+	c.PushAt(job, time.Now().Add(d), hashcode...)
+}
+
+// PushAt 追加定时任务, 到达 t 之后才能执行
+// hashcode 参数仅在开启 WithKeyedSerial 时作为顺序键使用
+func (c *singleQueue) PushAt(job Job, t time.Time, hashcode ...int64) {
+	_ = c.push(context.Background(), &element{job: job, at: t.UnixNano()}, true, hashcode)
+}
+
+// 追加任务
+// wait 表示阻塞策略下队列已满时是否等待空位
+func (c *singleQueue) push(ctx context.Context, ele *element, wait bool, hashcode []int64) error {
+	if ele.job == nil && ele.ctxJob == nil && ele.errJob == nil {
+		return nil
+	}
+	ele.ctx = ctx
+	if len(hashcode) > 0 {
+		ele.key, ele.hashed, ele.keyed = hashcode[0], true, c.conf.keyedSerial
+	}
+	if c.conf.keyLimit != nil {
+		ele.limitKey = limitKey(ctx)
+	}
+	if c.conf.priority && ele.priority == 0 {
+		ele.priority = jobPriority(ctx)
+	}
+	ele.stealable = len(c.siblings) > 0 && !ele.hashed && ele.limitKey == ""
 
-	if !c.stopped && newJob != nil {
-		c.q.PushBack(newJob)
+	var now int64
+	if c.timed || ele.at > 0 {
+		now = time.Now().UnixNano()
 	}
-	c.curConcurrency += delta
-	if c.curConcurrency >= c.maxConcurrency {
+	ele.deadline = c.deadline(ctx, ele.at, &now)
+	ele.timeout = c.jobTimeout(ctx)
+	ele.onDrop = dropHook(ctx)
+	if c.lockFree && ele.postable(now) {
+		return c.post(ele, now)
+	}
+	c.mu.Lock()
+	c.collect()
+	for {
+		if c.stopped {
+			c.mu.Unlock()
+			return ErrQueueStopped
+		}
+		if merged, err := c.dedup(ele); merged {
+			c.mu.Unlock()
+			return err
+		}
+		if !c.full() {
+			break
+		}
+
+		switch c.conf.overflow {
+		case OverflowReject:
+			return c.reject()
+		case OverflowCallerRuns:
+			if c.paused || c.busy(ele) || !c.acquire() {
+				return c.reject()
+			}
+			if !c.tryAdmit(ele) {
+				c.refund()
+				return c.reject()
+			}
+			if ele.keyed {
+				c.serial[ele.key] = nil
+			}
+			c.mu.Unlock()
+			c.callerRuns(ele)
+			return nil
+		case OverflowDropOldest:
+			dropped, ok := c.q.Drop()
+			if !ok {
+				return c.reject()
+			}
+			c.stats.dropped++
+			dropped.drop(ErrQueueFull)
+			c.releaseUnique(dropped)
+			c.release(dropped)
+			if dropped.durable != nil {
+				// 写日志可能阻塞, 释放锁后再标记完成, 重新加锁后再次检查队列状态
+				c.mu.Unlock()
+				_ = c.conf.wal.done(dropped.durable.ID)
+				c.mu.Lock()
+			}
+		default:
+			if !wait {
+				return c.reject()
+			}
+			if c.notFull == nil {
+				c.notFull = make(chan struct{})
+			}
+			var ch = c.notFull
+			c.mu.Unlock()
+			select {
+			case <-ch:
+				now = time.Now().UnixNano()
+				c.mu.Lock()
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	if ele.at > now {
+		c.delayed.Push(ele)
+		c.delayed.Reset(now, c.onTimer)
+		c.mu.Unlock()
 		return nil
 	}
-	if job := c.q.PopFront(); job != nil {
-		c.curConcurrency++
-		return job
+
+	ele.at, ele.pushedAt = 0, now
+	if c.handoff(ele, now) {
+		c.mu.Unlock()
+		c.launch(ele)
+		return nil
+	}
+	c.indexUnique(ele)
+	c.enqueue(ele)
+	nextJob, ok := c.takeJob(now)
+	c.mu.Unlock()
+
+	if ok {
+		c.launch(nextJob)
+	} else if ele.stealable {
+		c.nudge()
 	}
 	return nil
 }
 
-// 循环执行任务
-func (c *singleQueue) do(job Job) {
-	for job != nil {
-		c.conf.caller(c.conf.logger, job)
-		job = c.getJob(nil, -1)
+// 任务队列为空且有空闲并发时, 任务不经过任务队列直接交给新的协程执行, 缩短持有锁的时间
+// 与先放入任务队列再取出的结果相同, 调用方需持有锁
+func (c *singleQueue) handoff(ele *element, now int64) bool {
+	if c.q.Len() > 0 || c.paused || c.curConcurrency.Load() >= c.maxConcurrency.Load() || c.busy(ele) || ele.limitKey != "" || ele.expired(&now) || !c.acquire() {
+		return false
 	}
+	if ele.keyed {
+		c.serial[ele.key] = nil
+	}
+	c.curConcurrency.Add(1)
+	c.dequeued(ele, now)
+	return true
 }
 
-// Push 追加任务, 有资源空闲的话会立即执行
-// hashcode 参数对单队列无效，仅为接口兼容性保留
-func (c *singleQueue) Push(job Job, hashcode ...int64) {
-	if nextJob := c.getJob(job, 0); nextJob != nil {
-		go c.do(nextJob)
+// 拒绝任务并释放锁
+func (c *singleQueue) reject() error {
+	c.stats.rejected++
+	c.mu.Unlock()
+	return ErrQueueFull
+}
+
+// 队列是否已满, 调用方需持有锁
+func (c *singleQueue) full() bool {
+	return c.conf.capacity > 0 && c.size() >= c.conf.capacity
+}
+
+// 剩余任务数量, 先将无锁追加的任务移入任务队列, 调用方需持有锁
+func (c *singleQueue) size() int {
+	c.collect()
+	return c.q.Len() + c.delayed.Len() + c.backlog + c.parked
+}
+
+// 将任务放入任务队列, 调用方需持有锁
+// 顺序模式下, 同一顺序键同时只有一个任务在任务队列中或者执行中, 其余任务按追加顺序暂存在积压队列
+// 重试中的任务已经持有顺序键, 直接放入任务队列
+func (c *singleQueue) enqueue(ele *element) {
+	if ele.keyed && ele.attempt == 0 {
+		if backlog, exists := c.serial[ele.key]; exists {
+			if backlog == nil {
+				backlog = internal.NewRing[*element](8)
+				c.serial[ele.key] = backlog
+			}
+			backlog.PushBack(ele)
+			c.backlog++
+			return
+		}
+		c.serial[ele.key] = nil
+	}
+	c.admit(ele)
+}
+
+// 顺序键是否有任务在任务队列中或者执行中, 调用方需持有锁
+func (c *singleQueue) busy(ele *element) bool {
+	if !ele.keyed {
+		return false
+	}
+	_, exists := c.serial[ele.key]
+	return exists
+}
+
+// 任务执行完成或者被丢弃后释放顺序键, 并将同一顺序键的下一个任务放入任务队列, 调用方需持有锁
+func (c *singleQueue) release(ele *element) {
+	c.releaseKey(ele)
+	if !ele.keyed {
+		return
+	}
+	var backlog = c.serial[ele.key]
+	if backlog == nil || backlog.Len() == 0 {
+		delete(c.serial, ele.key)
+		return
+	}
+	c.backlog--
+	next, _ := backlog.PopFront()
+	c.admit(next)
+}
+
+// 取出所有未执行的任务, 包含积压的任务和未到期的延迟任务, 调用方需持有锁
+func (c *singleQueue) clear() []*element {
+	var eles = make([]*element, 0, c.size())
+	for ele, ok := c.q.Pop(); ok; ele, ok = c.q.Pop() {
+		eles = append(eles, ele)
+	}
+	for _, backlog := range c.serial {
+		for backlog != nil && backlog.Len() > 0 {
+			ele, _ := backlog.PopFront()
+			eles = append(eles, ele)
+		}
+	}
+	c.serial = make(map[int64]*internal.Ring[*element])
+	c.unique = make(map[string]*uniqueJob)
+	eles = c.clearKeys(eles)
+	c.backlog = 0
+	for ele, ok := c.delayed.PopDue(math.MaxInt64); ok; ele, ok = c.delayed.PopDue(math.MaxInt64) {
+		eles = append(eles, ele)
+	}
+	c.delayed.Clear()
+	return eles
+}
+
+// 丢弃未到期的延迟任务并返回数量, 释放重试中的任务持有的顺序键, 调用方需持有锁
+// 释放顺序键后积压的任务会进入任务队列, 调用方需要取出执行
+func (c *singleQueue) discardDelayed() int {
+	var n = 0
+	for {
+		ele, ok := c.delayed.PopDue(math.MaxInt64)
+		if !ok {
+			break
+		}
+		n++
+		ele.drop(ErrQueueStopped)
+		if ele.attempt > 0 {
+			c.release(ele)
+		}
+	}
+	c.delayed.Clear()
+	return n
+}
+
+// 唤醒等待空位的生产者, 调用方需持有锁
+func (c *singleQueue) signal() {
+	if c.notFull != nil {
+		close(c.notFull)
+		c.notFull = nil
+	}
+}
+
+// 定时器回调, 将到期的任务移入任务队列并执行
+func (c *singleQueue) onTimer() {
+	c.mu.Lock()
+	c.collect()
+	var now = time.Now().UnixNano()
+	for {
+		ele, ok := c.delayed.PopDue(now)
+		if !ok {
+			break
+		}
+		ele.at, ele.pushedAt = 0, now
+		c.enqueue(ele)
+	}
+	c.delayed.at = 0
+	c.delayed.Reset(now, c.onTimer)
+	var jobs = c.takeJobs(now)
+	c.mu.Unlock()
+
+	c.spawn(jobs)
+}
+
+// 在并发限制内取出尽可能多的任务, 调用方需持有锁
+func (c *singleQueue) takeJobs(now int64) []*element {
+	var jobs []*element
+	for job, ok := c.takeJob(now); ok; job, ok = c.takeJob(now) {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// 为每个任务启动一个协程循环执行
+func (c *singleQueue) spawn(jobs []*element) {
+	for _, job := range jobs {
+		c.launch(job)
+	}
+}
+
+// SetConcurrency 设置最大并发, n 为0时忽略
+// 调低时正在执行的任务不受影响, 只是不再启动新任务直到并发低于新的限制; 调高时立即执行排队中的任务
+func (c *singleQueue) SetConcurrency(n uint32) {
+	if n == 0 {
+		return
 	}
+	c.mu.Lock()
+	c.maxConcurrency.Store(int32(n))
+	var jobs = c.takeJobs(time.Now().UnixNano())
+	c.mu.Unlock()
+
+	c.spawn(jobs)
+}
+
+// Pause 暂停分派任务, 仍然可以追加任务, 正在执行的任务不受影响
+func (c *singleQueue) Pause() {
+	c.mu.Lock()
+	c.paused = true
+	c.mu.Unlock()
+}
+
+// Resume 恢复分派任务, 立即执行排队中的任务
+func (c *singleQueue) Resume() {
+	c.mu.Lock()
+	c.paused = false
+	var jobs = c.takeJobs(time.Now().UnixNano())
+	c.mu.Unlock()
+
+	c.spawn(jobs)
+}
+
+// Concurrency 获取最大并发
+func (c *singleQueue) Concurrency() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint32(c.maxConcurrency.Load())
 }
 
+// Len 获取剩余任务数量, 包含未到期的延迟任务
 func (c *singleQueue) Len() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.q.Len()
+	return c.size()
 }
 
-func (c *singleQueue) finish() bool {
+// Stats 获取统计快照
+func (c *singleQueue) Stats() Stats {
+	var s = c.shardStats()
+	s.Shards = []Stats{s}
+	return s
+}
+
+// 获取本分片的统计快照
+func (c *singleQueue) shardStats() Stats {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.q.Len()+int(c.curConcurrency) == 0
+	var s = c.stats.snapshot(c.size(), int(c.curConcurrency.Load()))
+	if c.keys != nil {
+		c.keys.sweep(c.conf.clock.Now())
+		s.Keys = len(c.keys.keys)
+	}
+	s.Paused = c.paused
+	s.Idle = int(c.idle.Load())
+	s.Stuck = c.stuckJobs()
+	return s
 }
 
-func (c *singleQueue) cas(old, new bool) bool {
+func (c *singleQueue) finish() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.stopped == old {
-		c.stopped = new
-		return true
+	return c.size()+int(c.curConcurrency.Load()) == 0
+}
+
+// 执行任务的协程
+// 每个协程复用一个 worker, 将绑定的执行函数交给 Caller, 避免每个任务都分配闭包
+type worker struct {
+	q         *singleQueue
+	ele       *element           // 当前任务
+	ctx       context.Context    // 当前任务的上下文
+	call      func()             // 绑定的执行函数
+	panicked  bool               // 当前任务是否发生了 panic
+	err       error              // 当前任务返回的错误
+	retryAt   int64              // 当前任务的重试时间, 为0表示不重试
+	recovered any                // 当前任务 panic 的值
+	stack     []byte             // 当前任务 panic 时的调用栈
+	elapsed   time.Duration      // 当前任务执行耗时
+	end       int64              // 当前任务执行结束的时间
+	timer     *time.Timer        // 常驻协程的空闲计时器
+	inline    bool               // 是否在调用方协程中执行, 不占用并发槽位
+	seq       uint64             // 任务序号
+	state     atomic.Uint64      // 任务序号和执行状态
+	watchdog  *time.Timer        // 执行超时计时器
+	cancelJob context.CancelFunc // 取消任务上下文
+	abandoned bool               // 当前任务是否超时后被放弃
+}
+
+func newWorker(q *singleQueue) *worker {
+	w := &worker{q: q}
+	w.call = w.run
+	return w
+}
+
+// 没有任务时挂起等待新任务, 空闲超时或者队列停止后返回 false, 未开启常驻协程池时直接返回 false
+// 挂起的协程不占用并发槽位, 新任务的槽位由分派方占用
+func (c *worker) park() (ele *element, ok bool) {
+	var q = c.q
+	if q.ready == nil {
+		return ele, false
+	}
+	c.ele = nil
+
+	var timeout <-chan time.Time
+	if d := q.conf.idleTimeout; d > 0 {
+		if c.timer == nil {
+			c.timer = time.NewTimer(d)
+		} else {
+			c.timer.Reset(d)
+		}
+		timeout = c.timer.C
+	}
+
+	q.idle.Add(1)
+	defer q.idle.Add(-1)
+	select {
+	case ele = <-q.ready:
+		if c.timer != nil && !c.timer.Stop() {
+			<-c.timer.C
+		}
+		return ele, true
+	case <-timeout:
+		return ele, false
+	case <-q.ctx.Done():
+		return ele, false
+	}
+}
+
+func (c *worker) run() {
+	if len(c.q.conf.onPanic) > 0 || c.q.conf.deadLetter != nil {
+		defer c.recover()
+	}
+	c.panicked = true
+	if c.ele.errJob != nil {
+		c.err = c.ele.errJob(c.ctx)
+	} else if c.ele.ctxJob != nil {
+		c.ele.ctxJob(c.ctx)
+	} else {
+		c.ele.job()
+	}
+	c.panicked = false
+}
+
+// 当前任务执行结束的时间, 未记录时读取时钟
+func (c *worker) now() int64 {
+	return readNow(&c.end)
+}
+
+// 任务返回错误, 计算重试时间, 不再重试时记录日志
+func (c *worker) failed() {
+	if d, ok := c.q.conf.retry.backoff(c.ele.attempt+1, c.err); ok {
+		c.retryAt = c.now() + int64(d)
+		return
+	}
+	c.q.conf.logger.Errorf("queues: job failed after %d attempts: %v", c.ele.attempt+1, c.err)
+	c.deadLetter(c.err)
+}
+
+// 将当前任务发送到死信接收器
+func (c *worker) deadLetter(err error) {
+	var sink = c.q.conf.deadLetter
+	if sink == nil {
+		return
+	}
+	var ele = c.ele
+	var letter = DeadLetter{
+		Priority: ele.priority,
+		Err:      err,
+		Panic:    c.recovered,
+		Stack:    c.stack,
+		Attempts: ele.attempt + 1,
+		FailedAt: time.Unix(0, c.now()),
+	}
+	letter.Job = ele.origin()
+	if ele.hashed {
+		letter.Hashcode = []int64{ele.key}
+	}
+	if ele.durable != nil {
+		letter.Name, letter.Payload = ele.durable.Name, ele.durable.Payload
+	}
+	if e := sink.Put(letter); e != nil {
+		c.q.conf.logger.Errorf("queues: failed to put dead letter: %v", e)
+	}
+}
+
+// 任务发生 panic 时调用钩子, 然后继续 panic, 交由 Caller 处理
+func (c *worker) recover() {
+	if !c.panicked {
+		return
+	}
+	if e := recover(); e != nil {
+		c.recovered, c.stack = e, debug.Stack()
+		for _, f := range c.q.conf.onPanic {
+			f(e, c.stack)
+		}
+		panic(e)
+	}
+}
+
+// 执行一个任务
+func (c *worker) exec(ele *element) {
+	c.ele = ele
+	if ele.plain {
+		// 只有任务函数的任务, 队列没有开启时间相关的功能, 不需要上下文, 计时和钩子
+		c.err, c.retryAt, c.recovered, c.stack = nil, 0, nil, nil
+		c.end = 0
+		c.q.conf.caller(c.q.conf.logger, c.call)
+		if c.panicked {
+			c.deadLetter(ErrJobPanic)
+		}
+		return
+	}
+	c.ctx = c.q.ctx
+	c.err, c.retryAt, c.recovered, c.stack = nil, 0, nil, nil
+	c.end = 0
+	var span tracing.Span
+	if c.q.conf.tracer != nil {
+		span = c.startSpan()
+	} else if ele.ctxJob != nil || ele.errJob != nil {
+		c.ctx = internal.WithValues(c.q.ctx, ele.ctx)
+	}
+
+	c.watch()
+	for _, f := range c.q.conf.onJobStart {
+		f(time.Duration(ele.startedAt - ele.pushedAt))
+	}
+	c.q.conf.caller(c.q.conf.logger, c.call)
+	c.unwatch()
+	if c.q.timed {
+		c.end = time.Now().UnixNano()
+		c.elapsed = time.Duration(c.end - ele.startedAt)
+		for _, f := range c.q.conf.onJobFinish {
+			f(c.elapsed)
+		}
+	}
+	if c.err != nil {
+		c.failed()
+	} else if c.panicked {
+		c.deadLetter(ErrJobPanic)
+	}
+	if ele.durable != nil && c.retryAt == 0 {
+		if err := c.q.conf.wal.done(ele.durable.ID); err != nil {
+			c.q.conf.logger.Errorf("queues: failed to complete job %d: %v", ele.durable.ID, err)
+		}
+	}
+
+	if span != nil {
+		c.endSpan(span)
+	}
+}
+
+// 创建任务的 span, 开始时间为进入任务队列的时间
+func (c *worker) startSpan() tracing.Span {
+	var ele = c.ele
+	ctx, span := c.q.conf.tracer.Start(ele.ctx, spanName, time.Unix(0, ele.pushedAt))
+	span.AddEvent(tracing.EventJobStart, time.Unix(0, ele.startedAt))
+	c.ctx = internal.WithValues(c.q.ctx, ctx)
+	return span
+}
+
+// 结束任务的 span
+func (c *worker) endSpan(span tracing.Span) {
+	span.SetAttributes(
+		tracing.Int64(tracing.AttrWait, c.ele.startedAt-c.ele.pushedAt),
+		tracing.Int64(tracing.AttrExec, int64(c.elapsed)),
+		tracing.Bool(tracing.AttrPanicked, c.panicked),
+	)
+	if c.panicked {
+		span.RecordError(ErrJobPanic)
+	} else if c.err != nil {
+		span.RecordError(c.err)
 	}
-	return false
+	span.End(time.Unix(0, c.end))
 }
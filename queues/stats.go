@@ -0,0 +1,89 @@
+package queues
+
+import "github.com/lxzan/concurrency/internal"
+
+// Histogram 时长分布
+type Histogram = internal.Histogram
+
+type (
+	// Stats 队列统计快照
+	Stats struct {
+		Pending      int           // 剩余任务数量, 包含未到期的延迟任务
+		Running      int           // 执行中的任务数量
+		Paused       bool          // 是否暂停
+		Idle         int           // 空闲的常驻协程数量, 需要开启 WithWorkerPool
+		Keys         int           // 按键限制时跟踪的键数量, 需要开启 WithKeyLimit
+		Completed    uint64        // 已执行完成的任务数量, 包含发生 panic 的任务, 重试的任务每次执行都会计数
+		Panicked     uint64        // 发生 panic 的任务数量, 需要开启 WithRecovery
+		Failed       uint64        // 返回错误且不再重试的任务数量
+		Retried      uint64        // 重试次数
+		Rejected     uint64        // 因队列已满被拒绝的任务数量
+		Dropped      uint64        // 因队列已满或者停止被丢弃的任务数量
+		Deduplicated uint64        // PushUnique 因重复被丢弃或者被替换的任务数量
+		Expired      uint64        // 过了截止时间仍未开始执行而被丢弃的任务数量
+		TimedOut     uint64        // 执行超时的任务数量
+		Abandoned    uint64        // 超时后忽略了取消, 被释放并发槽位的任务数量
+		Stuck        []TimedOutJob // 超时后忽略了取消仍在执行的任务, 不计入 Running
+		WaitLatency  Histogram     // 排队等待时长分布, 需要开启 WithLatencyStats
+		ExecLatency  Histogram     // 执行时长分布, 需要开启 WithLatencyStats
+		Shards       []Stats       // 各个分片的统计, 仅在聚合统计中有效
+	}
+
+	// 分片内部的统计, 由 singleQueue 加锁访问
+	queueStats struct {
+		completed    uint64
+		panicked     uint64
+		failed       uint64
+		retried      uint64
+		rejected     uint64
+		dropped      uint64
+		deduplicated uint64
+		expired      uint64
+		timedOut     uint64
+		abandoned    uint64
+		wait         internal.Recorder
+		exec         internal.Recorder
+	}
+)
+
+func (c *queueStats) snapshot(pending, running int) Stats {
+	return Stats{
+		Pending:      pending,
+		Running:      running,
+		Completed:    c.completed,
+		Panicked:     c.panicked,
+		Failed:       c.failed,
+		Retried:      c.retried,
+		Rejected:     c.rejected,
+		Dropped:      c.dropped,
+		Deduplicated: c.deduplicated,
+		Expired:      c.expired,
+		TimedOut:     c.timedOut,
+		Abandoned:    c.abandoned,
+		WaitLatency:  c.wait.Snapshot(),
+		ExecLatency:  c.exec.Snapshot(),
+	}
+}
+
+// 聚合另一个分片的统计
+func (c *Stats) merge(s Stats) {
+	c.Pending += s.Pending
+	c.Running += s.Running
+	c.Paused = c.Paused || s.Paused
+	c.Idle += s.Idle
+	c.Keys += s.Keys
+	c.Completed += s.Completed
+	c.Panicked += s.Panicked
+	c.Failed += s.Failed
+	c.Retried += s.Retried
+	c.Rejected += s.Rejected
+	c.Dropped += s.Dropped
+	c.Deduplicated += s.Deduplicated
+	c.Expired += s.Expired
+	c.TimedOut += s.TimedOut
+	c.Abandoned += s.Abandoned
+	c.Stuck = append(c.Stuck, s.Stuck...)
+	c.WaitLatency.Merge(s.WaitLatency)
+	c.ExecLatency.Merge(s.ExecLatency)
+	c.Shards = append(c.Shards, s)
+}
@@ -0,0 +1,74 @@
+package queues
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrDuplicateJob 相同键的任务正在等待执行, 新任务被丢弃
+var ErrDuplicateJob = errors.New("queues: duplicate job")
+
+// UniquePolicy 相同键的任务正在等待执行时的去重策略
+type UniquePolicy uint8
+
+const (
+	// UniqueDrop 丢弃新任务, PushUnique 返回 ErrDuplicateJob
+	UniqueDrop UniquePolicy = iota
+
+	// UniqueReplace 用新任务替换等待中的任务, 替换后的任务保持原来的排队位置
+	UniqueReplace
+)
+
+// 去重索引中的任务, 替换时只更新任务函数
+type uniqueJob struct {
+	key string
+	job Job
+}
+
+// WithUniquePolicy 设置 PushUnique 的去重策略, 默认为 UniqueDrop
+func WithUniquePolicy(policy UniquePolicy) Option {
+	return func(o *options) {
+		o.unique = policy
+	}
+}
+
+// PushUnique 追加去重任务, 返回任务被拒绝的原因
+// 相同 key 的任务正在等待执行时按去重策略处理, 任务开始执行后释放 key, 之后追加的任务不再与其去重
+func (c *singleQueue) PushUnique(ctx context.Context, key string, job Job, hashcode ...int64) error {
+	return c.push(ctx, &element{job: job, unique: &uniqueJob{key: key, job: job}}, true, hashcode)
+}
+
+// 按去重策略处理等待中的相同键的任务, 返回 true 表示新任务已被合并, 调用方需持有锁
+func (c *singleQueue) dedup(ele *element) (bool, error) {
+	if ele.unique == nil {
+		return false, nil
+	}
+	var pending, ok = c.unique[ele.unique.key]
+	if !ok {
+		return false, nil
+	}
+	c.stats.deduplicated++
+	if c.conf.unique == UniqueReplace {
+		pending.job = ele.unique.job
+		return true, nil
+	}
+	return true, ErrDuplicateJob
+}
+
+// 任务进入等待状态, 加入去重索引, 调用方需持有锁
+func (c *singleQueue) indexUnique(ele *element) {
+	if ele.unique != nil {
+		c.unique[ele.unique.key] = ele.unique
+	}
+}
+
+// 任务开始执行或者被丢弃, 从去重索引中移除, 调用方需持有锁
+func (c *singleQueue) releaseUnique(ele *element) {
+	if ele.unique == nil {
+		return
+	}
+	if c.unique[ele.unique.key] == ele.unique {
+		delete(c.unique, ele.unique.key)
+	}
+	ele.job, ele.unique = ele.unique.job, nil
+}
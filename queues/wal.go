@@ -0,0 +1,292 @@
+package queues
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	walFileName         = "queue.wal" // 日志文件名
+	walCompactThreshold = 1024        // 触发压缩的过期记录数量
+
+	walOpAdd  = "add"  // 追加任务
+	walOpDone = "done" // 任务完成
+)
+
+var (
+	// ErrNoWAL 没有开启持久化
+	ErrNoWAL = errors.New("queues: write-ahead log is not enabled")
+
+	// ErrUnknownHandler 持久化任务的处理函数没有注册
+	ErrUnknownHandler = errors.New("queues: unknown handler")
+
+	// ErrWALClosed 日志已关闭
+	ErrWALClosed = errors.New("queues: write-ahead log is closed")
+)
+
+type (
+	// Handler 持久化任务的处理函数, payload 为追加任务时的参数
+	// 返回错误时按 WithRetry 设置的策略重试
+	Handler func(ctx context.Context, payload []byte) error
+
+	// WAL 预写日志, 用于持久化任务
+	// 任务在写入日志并刷盘之后才会追加到任务队列, 执行结束(成功或者最终失败)后标记为完成;
+	// 重启后未完成的任务会重新执行, 即至少执行一次.
+	// 日志只追加写入, 过期记录达到一定数量后重写日志文件以压缩空间.
+	WAL struct {
+		mu        sync.Mutex
+		path      string
+		file      *os.File
+		seq       uint64               // 最大的任务编号
+		pending   map[uint64]walRecord // 未完成的任务
+		records   int                  // 日志文件中的记录数量
+		threshold int                  // 触发压缩的过期记录数量
+		closed    bool
+	}
+
+	// 日志记录
+	walRecord struct {
+		Op       string  `json:"op"`
+		ID       uint64  `json:"id"`
+		Name     string  `json:"name,omitempty"`
+		Payload  []byte  `json:"payload,omitempty"`
+		Hashcode []int64 `json:"hashcode,omitempty"`
+		LimitKey string  `json:"limit_key,omitempty"`
+	}
+)
+
+// WithWAL 开启持久化, handlers 为任务名称到处理函数的映射
+// New 会立即将日志中未完成的任务追加到任务队列; 处理函数没有注册的任务保留在日志中, 不会执行
+// 日志保存任务的 hashcode 和限制键, 不保存截止时间, 存活时长和优先级, 恢复的任务按队列的默认设置从恢复时开始计算
+func WithWAL(wal *WAL, handlers map[string]Handler) Option {
+	return func(o *options) {
+		o.wal = wal
+		o.handlers = handlers
+	}
+}
+
+// OpenWAL 打开 dir 目录下的预写日志, 目录不存在时创建
+// 打开时会读取未完成的任务并压缩日志; 日志末尾不完整的记录(例如写入时进程崩溃)会被丢弃
+func OpenWAL(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var c = &WAL{
+		path:      filepath.Join(dir, walFileName),
+		pending:   make(map[uint64]walRecord),
+		threshold: walCompactThreshold,
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if err := c.compact(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 读取日志文件
+func (c *WAL) load() error {
+	file, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader = bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var record walRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		if record.ID > c.seq {
+			c.seq = record.ID
+		}
+		if record.Op == walOpAdd {
+			c.pending[record.ID] = record
+		} else {
+			delete(c.pending, record.ID)
+		}
+	}
+}
+
+// Len 获取未完成的任务数量
+func (c *WAL) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Compact 压缩日志, 只保留未完成的任务
+func (c *WAL) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrWALClosed
+	}
+	return c.compact()
+}
+
+// Close 关闭日志, 应当在任务队列停止之后调用
+func (c *WAL) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.file.Close()
+}
+
+// 将未完成的任务写入临时文件, 然后替换日志文件
+func (c *WAL) compact() error {
+	var tmp = c.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var writer = bufio.NewWriter(file)
+	for _, record := range c.sorted() {
+		b, _ := json.Marshal(record)
+		_, _ = writer.Write(append(b, '\n'))
+	}
+	if err = writer.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(c.path))
+
+	if c.file != nil {
+		_ = c.file.Close()
+	}
+	if c.file, err = os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		c.closed = true
+		return err
+	}
+	c.records = len(c.pending)
+	return nil
+}
+
+// 按编号排序的未完成任务
+func (c *WAL) sorted() []walRecord {
+	var records = make([]walRecord, 0, len(c.pending))
+	for _, record := range c.pending {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+// 获取所有未完成的任务
+func (c *WAL) entries() []walRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sorted()
+}
+
+// 写入一条记录, 调用方需持有锁
+func (c *WAL) write(record walRecord, sync bool) error {
+	if c.closed {
+		return ErrWALClosed
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = c.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	c.records++
+	if sync {
+		return c.file.Sync()
+	}
+	return nil
+}
+
+// 追加任务并刷盘, 返回任务编号
+func (c *WAL) add(name string, payload []byte, hashcode []int64, limitKey string) (walRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var record = walRecord{Op: walOpAdd, ID: c.seq + 1, Name: name, Payload: payload, LimitKey: limitKey}
+	if len(hashcode) > 0 {
+		record.Hashcode = hashcode[:1]
+	}
+	if err := c.write(record, true); err != nil {
+		return record, err
+	}
+	c.seq++
+	c.pending[record.ID] = record
+	return record, nil
+}
+
+// 标记任务完成, 过期记录足够多时压缩日志
+// 完成记录不刷盘, 进程崩溃时最多导致任务重复执行
+func (c *WAL) done(id uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pending[id]; !ok {
+		return nil
+	}
+	if err := c.write(walRecord{Op: walOpDone, ID: id}, false); err != nil {
+		return err
+	}
+	delete(c.pending, id)
+	if c.records-len(c.pending) >= c.threshold {
+		return c.compact()
+	}
+	return nil
+}
+
+// 刷新目录, 保证重命名持久化
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		_ = f.Sync()
+		_ = f.Close()
+	}
+}
+
+// 将日志中未完成的任务追加到任务队列
+func replay(q Queue, o *options) {
+	for _, record := range o.wal.entries() {
+		var ctx = context.Background()
+		if record.LimitKey != "" {
+			ctx = WithLimitKey(ctx, record.LimitKey)
+		}
+		var shard *singleQueue
+		switch v := q.(type) {
+		case *singleQueue:
+			shard = v
+		case *multipleQueue:
+			shard = v.routeContext(ctx, record.Hashcode)
+		}
+		if err := shard.pushRecord(ctx, record); err != nil {
+			o.logger.Errorf("queues: failed to replay job %d(%s): %v", record.ID, record.Name, err)
+		}
+	}
+}
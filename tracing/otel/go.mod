@@ -0,0 +1,26 @@
+module github.com/lxzan/concurrency/tracing/otel
+
+go 1.20
+
+// 适配器依赖根模块中尚未发布的 tracing 包, 根模块发布带有 tracing 包的版本之前, 本模块只能在仓库内通过本地代码构建
+// 发布之后将 require 改为该版本并删除 replace, 再发布本模块
+replace github.com/lxzan/concurrency => ../../
+
+require (
+	github.com/lxzan/concurrency v0.0.0
+	github.com/stretchr/testify v1.8.4
+	go.opentelemetry.io/otel v1.19.0
+	go.opentelemetry.io/otel/sdk v1.19.0
+	go.opentelemetry.io/otel/trace v1.19.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-logr/logr v1.2.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/lxzan/dao v1.1.12 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	go.opentelemetry.io/otel/metric v1.19.0 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
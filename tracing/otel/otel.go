@@ -0,0 +1,61 @@
+// Package otel 将 tracing.Tracer 适配到 OpenTelemetry
+package otel
+
+import (
+	"context"
+	"time"
+
+	"github.com/lxzan/concurrency/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	otelTracer struct {
+		tracer trace.Tracer
+	}
+
+	otelSpan struct {
+		span trace.Span
+	}
+)
+
+// NewTracer 使用 OpenTelemetry 的 Tracer 创建 tracing.Tracer
+// 任务 span 的类型为 SpanKindConsumer, 发生错误或者 panic 时状态为 Error
+func NewTracer(tracer trace.Tracer) tracing.Tracer {
+	return &otelTracer{tracer: tracer}
+}
+
+func (c *otelTracer) Start(ctx context.Context, name string, at time.Time) (context.Context, tracing.Span) {
+	ctx, span := c.tracer.Start(ctx, name, trace.WithTimestamp(at), trace.WithSpanKind(trace.SpanKindConsumer))
+	return ctx, &otelSpan{span: span}
+}
+
+func (c *otelSpan) AddEvent(name string, at time.Time) {
+	c.span.AddEvent(name, trace.WithTimestamp(at))
+}
+
+func (c *otelSpan) SetAttributes(attrs ...tracing.Attribute) {
+	var kvs = make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		switch v := attr.Value.(type) {
+		case string:
+			kvs = append(kvs, attribute.String(attr.Key, v))
+		case int64:
+			kvs = append(kvs, attribute.Int64(attr.Key, v))
+		case bool:
+			kvs = append(kvs, attribute.Bool(attr.Key, v))
+		}
+	}
+	c.span.SetAttributes(kvs...)
+}
+
+func (c *otelSpan) RecordError(err error) {
+	c.span.RecordError(err)
+	c.span.SetStatus(codes.Error, err.Error())
+}
+
+func (c *otelSpan) End(at time.Time) {
+	c.span.End(trace.WithTimestamp(at))
+}
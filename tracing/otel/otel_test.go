@@ -0,0 +1,45 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lxzan/concurrency/queues"
+	"github.com/lxzan/concurrency/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracer(t *testing.T) {
+	as := assert.New(t)
+	var recorder = tracetest.NewSpanRecorder()
+	var provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	var tracer = provider.Tracer("test")
+
+	q := queues.New(queues.WithRecovery(), queues.WithTracer(NewTracer(tracer)))
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	var spanContext trace.SpanContext
+	as.NoError(q.PushWithContext(ctx, func(ctx context.Context) {
+		spanContext = trace.SpanContextFromContext(ctx)
+		panic("test")
+	}))
+	as.NoError(q.Stop(context.Background()))
+	parent.End()
+
+	var spans = recorder.Ended()
+	as.Len(spans, 2)
+	var span = spans[0]
+	as.Equal("queues.job", span.Name())
+	as.Equal(trace.SpanKindConsumer, span.SpanKind())
+	as.Equal(parent.SpanContext().SpanID(), span.Parent().SpanID())
+	as.Equal(parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+	as.Equal(span.SpanContext().SpanID(), spanContext.SpanID())
+	as.Equal(codes.Error, span.Status().Code)
+	as.Equal(tracing.EventJobStart, span.Events()[0].Name)
+	as.Contains(span.Attributes(), attribute.Bool(tracing.AttrPanicked, true))
+	as.False(span.EndTime().Before(span.StartTime()))
+}
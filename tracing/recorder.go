@@ -0,0 +1,116 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Recorder 在内存中记录 span 的 Tracer, 用于测试
+	Recorder struct {
+		mu    sync.Mutex
+		seq   uint64
+		spans []RecordedSpan
+	}
+
+	// RecordedSpan 已结束的 span
+	RecordedSpan struct {
+		ID         uint64      // 编号, 从1开始
+		ParentID   uint64      // 父 span 编号, 为0表示没有父 span
+		Name       string      // 名称
+		Start      time.Time   // 开始时间
+		End        time.Time   // 结束时间
+		Events     []Event     // 事件
+		Attributes []Attribute // 属性
+		Errors     []error     // 错误
+	}
+
+	// Event span 事件
+	Event struct {
+		Name string
+		Time time.Time
+	}
+
+	recorderSpan struct {
+		recorder *Recorder
+		data     RecordedSpan
+	}
+
+	recorderKey struct{}
+)
+
+// NewRecorder 新建内存记录器
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start 创建 span, 父 span 为 ctx 中由 Recorder 创建的 span
+func (c *Recorder) Start(ctx context.Context, name string, at time.Time) (context.Context, Span) {
+	c.mu.Lock()
+	c.seq++
+	var span = &recorderSpan{recorder: c, data: RecordedSpan{ID: c.seq, Name: name, Start: at}}
+	c.mu.Unlock()
+
+	if parent, ok := ctx.Value(recorderKey{}).(*recorderSpan); ok {
+		span.data.ParentID = parent.data.ID
+	}
+	return context.WithValue(ctx, recorderKey{}, span), span
+}
+
+// Spans 获取已结束的 span, 按结束顺序排列
+func (c *Recorder) Spans() []RecordedSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]RecordedSpan(nil), c.spans...)
+}
+
+// Reset 清空已记录的 span
+func (c *Recorder) Reset() {
+	c.mu.Lock()
+	c.spans = nil
+	c.mu.Unlock()
+}
+
+// SpanID 获取上下文中由 Recorder 创建的 span 的编号, 没有时返回0
+func SpanID(ctx context.Context) uint64 {
+	if span, ok := ctx.Value(recorderKey{}).(*recorderSpan); ok {
+		return span.data.ID
+	}
+	return 0
+}
+
+// Attribute 获取属性值
+func (c *RecordedSpan) Attribute(key string) (any, bool) {
+	for _, attr := range c.Attributes {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (c *recorderSpan) AddEvent(name string, at time.Time) {
+	c.recorder.mu.Lock()
+	c.data.Events = append(c.data.Events, Event{Name: name, Time: at})
+	c.recorder.mu.Unlock()
+}
+
+func (c *recorderSpan) SetAttributes(attrs ...Attribute) {
+	c.recorder.mu.Lock()
+	c.data.Attributes = append(c.data.Attributes, attrs...)
+	c.recorder.mu.Unlock()
+}
+
+func (c *recorderSpan) RecordError(err error) {
+	c.recorder.mu.Lock()
+	c.data.Errors = append(c.data.Errors, err)
+	c.recorder.mu.Unlock()
+}
+
+func (c *recorderSpan) End(at time.Time) {
+	c.recorder.mu.Lock()
+	c.data.End = at
+	c.recorder.spans = append(c.recorder.spans, c.data)
+	c.recorder.mu.Unlock()
+}
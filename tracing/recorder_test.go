@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	as := assert.New(t)
+	var recorder = NewRecorder()
+	var now = time.Now()
+
+	ctx, parent := recorder.Start(context.Background(), "parent", now)
+	as.Equal(uint64(1), SpanID(ctx))
+	as.Equal(uint64(0), SpanID(context.Background()))
+
+	_, child := recorder.Start(ctx, "child", now)
+	child.AddEvent("event", now.Add(time.Millisecond))
+	child.SetAttributes(String("s", "a"), Int64("i", 1), Bool("b", true))
+	child.RecordError(errors.New("test"))
+	child.End(now.Add(2 * time.Millisecond))
+	as.Len(recorder.Spans(), 1)
+	parent.End(now.Add(3 * time.Millisecond))
+
+	var spans = recorder.Spans()
+	as.Len(spans, 2)
+	as.Equal("child", spans[0].Name)
+	as.Equal(uint64(2), spans[0].ID)
+	as.Equal(uint64(1), spans[0].ParentID)
+	as.Equal([]Event{{Name: "event", Time: now.Add(time.Millisecond)}}, spans[0].Events)
+	as.Equal(2*time.Millisecond, spans[0].End.Sub(spans[0].Start))
+	as.Len(spans[0].Errors, 1)
+	v, ok := spans[0].Attribute("i")
+	as.True(ok)
+	as.Equal(int64(1), v)
+	_, ok = spans[0].Attribute("x")
+	as.False(ok)
+	as.Equal(uint64(0), spans[1].ParentID)
+
+	recorder.Reset()
+	as.Empty(recorder.Spans())
+}
@@ -0,0 +1,62 @@
+// Package tracing 链路追踪抽象
+// 任务队列和任务组通过 Tracer 为每个任务创建 span, 记录排队等待时长, 执行时长, panic 和错误.
+// span 作为追加任务时上下文中的 span 的子 span, 并通过任务上下文传递给任务.
+package tracing
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// Tracer 创建 span
+	Tracer interface {
+		// Start 在 ctx 中的 span 之下创建一个子 span, 返回携带新 span 的上下文
+		// at 为 span 的开始时间, 对任务而言即进入队列的时间
+		Start(ctx context.Context, name string, at time.Time) (context.Context, Span)
+	}
+
+	// Span 一次任务执行的追踪记录
+	Span interface {
+		// AddEvent 记录一个事件
+		AddEvent(name string, at time.Time)
+
+		// SetAttributes 设置属性
+		SetAttributes(attrs ...Attribute)
+
+		// RecordError 记录错误
+		RecordError(err error)
+
+		// End 结束 span, at 为结束时间
+		End(at time.Time)
+	}
+
+	// Attribute span 属性
+	Attribute struct {
+		Key   string
+		Value any // string, int64 或 bool
+	}
+)
+
+// String 新建字符串属性
+func String(key, value string) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int64 新建整数属性
+func Int64(key string, value int64) Attribute { return Attribute{Key: key, Value: value} }
+
+// Bool 新建布尔属性
+func Bool(key string, value bool) Attribute { return Attribute{Key: key, Value: value} }
+
+const (
+	// EventJobStart 任务开始执行的事件
+	EventJobStart = "job.start"
+
+	// AttrWait 排队等待时长(纳秒)
+	AttrWait = "job.wait_ns"
+
+	// AttrExec 执行时长(纳秒)
+	AttrExec = "job.exec_ns"
+
+	// AttrPanicked 是否发生了 panic
+	AttrPanicked = "job.panicked"
+)